@@ -1,5 +1,12 @@
 package auth
 
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
 type Action int
 
 const (
@@ -8,6 +15,22 @@ const (
 	ActionUpdate
 	ActionDelete
 	ActionReadMine // read with ?mine query, usually filter by user_id field
+
+	// actionCustom is the first value of custom actions
+	actionCustom
+)
+
+// actionBulk is the flag of bulk actions, a bulk action is checked against the
+// policies of its base action if there is no policy for it
+const actionBulk Action = 1 << 20
+
+const bulkPrefix = "bulk_"
+
+// The bulk variants of the builtin actions, e.g. create many rows at once
+var (
+	ActionBulkCreate = ActionCreate.Bulk()
+	ActionBulkUpdate = ActionUpdate.Bulk()
+	ActionBulkDelete = ActionDelete.Bulk()
 )
 
 var actionToStr = map[Action]string{
@@ -18,6 +41,107 @@ var actionToStr = map[Action]string{
 	ActionReadMine: "read_mine",
 }
 
+var (
+	actionsMu   sync.RWMutex
+	strToAction = map[string]Action{
+		"create":    ActionCreate,
+		"read":      ActionRead,
+		"update":    ActionUpdate,
+		"delete":    ActionDelete,
+		"read_mine": ActionReadMine,
+	}
+	nextAction = actionCustom
+)
+
+var actionNameExp = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// RegisterAction registers a custom action by name, e.g. "publish", so that
+// policies can reference it, registering a name again returns the same
+// action. Names are lower case letters, digits and underscores, and can't be
+// "all" or start with "bulk_", use Action.Bulk for the bulk variant.
+func RegisterAction(name string) (Action, error) {
+	if !actionNameExp.MatchString(name) || name == "all" || strings.HasPrefix(name, bulkPrefix) {
+		return 0, fmt.Errorf("invalid action name: %q", name)
+	}
+
+	actionsMu.Lock()
+	defer actionsMu.Unlock()
+	if a, ok := strToAction[name]; ok {
+		return a, nil
+	}
+	a := nextAction
+	nextAction++
+	actionToStr[a] = name
+	strToAction[name] = a
+	return a, nil
+}
+
+// MustRegisterAction is like RegisterAction but panics if name is invalid,
+// it simplifies the initialization of global variables
+func MustRegisterAction(name string) Action {
+	a, err := RegisterAction(name)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// ParseAction returns the action by name, including the bulk variants as
+// "bulk_<name>"
+func ParseAction(name string) (Action, error) {
+	bulk := strings.HasPrefix(name, bulkPrefix)
+	base := strings.TrimPrefix(name, bulkPrefix)
+	actionsMu.RLock()
+	a, ok := strToAction[base]
+	actionsMu.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("unknown action: %q", name)
+	}
+	if bulk {
+		a = a.Bulk()
+	}
+	return a, nil
+}
+
+// Bulk returns the bulk variant of the action
+func (a Action) Bulk() Action {
+	return a | actionBulk
+}
+
+// IsBulk returns whether the action is a bulk variant
+func (a Action) IsBulk() bool {
+	return a&actionBulk != 0
+}
+
+// Base returns the action without the bulk variant
+func (a Action) Base() Action {
+	return a &^ actionBulk
+}
+
 func (a Action) String() string {
-	return actionToStr[a]
+	actionsMu.RLock()
+	name, ok := actionToStr[a.Base()]
+	actionsMu.RUnlock()
+	if !ok {
+		return fmt.Sprintf("action(%d)", int(a))
+	}
+	if a.IsBulk() {
+		return bulkPrefix + name
+	}
+	return name
+}
+
+// MarshalText implements encoding.TextMarshaler interface
+func (a Action) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler interface
+func (a *Action) UnmarshalText(text []byte) error {
+	action, err := ParseAction(string(text))
+	if err != nil {
+		return err
+	}
+	*a = action
+	return nil
 }
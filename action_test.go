@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAction(t *testing.T) {
+	publish, err := RegisterAction("publish")
+	assert.Nil(t, err)
+	again, err := RegisterAction("publish")
+	assert.Nil(t, err)
+	assert.Equal(t, publish, again)
+	assert.Equal(t, "publish", publish.String())
+	assert.Equal(t, "bulk_publish", publish.Bulk().String())
+	assert.Equal(t, "bulk_delete", ActionBulkDelete.String())
+	assert.True(t, ActionBulkDelete.IsBulk())
+	assert.Equal(t, ActionDelete, ActionBulkDelete.Base())
+	assert.Equal(t, "action(99)", Action(99).String())
+
+	for _, name := range []string{"", "all", "bulk_export", "Publish", "publish now"} {
+		_, err := RegisterAction(name)
+		assert.NotNil(t, err, name)
+	}
+	assert.Panics(t, func() { MustRegisterAction("all") })
+
+	t.Run("parse", func(t *testing.T) {
+		for name, want := range map[string]Action{
+			"read":         ActionRead,
+			"read_mine":    ActionReadMine,
+			"bulk_create":  ActionBulkCreate,
+			"publish":      publish,
+			"bulk_publish": publish.Bulk(),
+		} {
+			a, err := ParseAction(name)
+			assert.Nil(t, err, name)
+			assert.Equal(t, want, a, name)
+		}
+		_, err := ParseAction("approve_all")
+		assert.NotNil(t, err)
+
+		var data struct {
+			Action Action `json:"action"`
+		}
+		assert.Nil(t, json.Unmarshal([]byte(`{"action": "bulk_publish"}`), &data))
+		assert.Equal(t, publish.Bulk(), data.Action)
+		b, err := json.Marshal(data)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"action":"bulk_publish"}`, string(b))
+	})
+
+	t.Run("has perm", func(t *testing.T) {
+		policies := map[string]map[string]string{
+			"articles": {
+				"publish":     "auth_user.has_role('editor')",
+				"delete":      "author_id = auth_user.id",
+				"bulk_delete": "auth_user.is_admin",
+				"all":         "",
+			},
+		}
+		editor := &User{ID: 1, Roles: []string{"editor"}}
+		hasPerm, _ := editor.HasPerm("articles", publish, policies)
+		assert.True(t, hasPerm)
+		// bulk falls back to the base action
+		hasPerm, _ = editor.HasPerm("articles", publish.Bulk(), policies)
+		assert.True(t, hasPerm)
+		hasPerm, _ = (&User{ID: 2}).HasPerm("articles", publish.Bulk(), policies)
+		assert.False(t, hasPerm)
+		hasPerm, _ = editor.HasPerm("articles", ActionBulkDelete, policies)
+		assert.False(t, hasPerm)
+		// no policy for the base action either, falls back to all
+		hasPerm, _ = (&User{ID: 2}).HasPerm("articles", ActionBulkUpdate, policies)
+		assert.True(t, hasPerm)
+	})
+}
@@ -4,61 +4,103 @@ package auth
 import (
 	"context"
 	"errors"
-	"fmt"
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/rest-go/rest/pkg/sql"
 )
 
-var primaryKeySQL = map[string]string{
-	"postgres": "BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY",
-	"mysql":    "BIGINT PRIMARY KEY AUTO_INCREMENT",
-	"sqlite":   "INTEGER PRIMARY KEY",
+// GenJWTToken generate and return jwt token signed by signer
+func GenJWTToken(signer Signer, data map[string]any) (string, error) {
+	return signer.Sign(data)
 }
 
-// GenJWTToken generate and return jwt token
-func GenJWTToken(secret []byte, data map[string]any) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(data))
-	return token.SignedString(secret)
+// ParseJWTToken parse tokenString and return data if token is valid
+func ParseJWTToken(verifier Verifier, tokenString string) (map[string]any, error) {
+	return ParseJWTTokenWithOptions(verifier, tokenString, &TokenOptions{})
 }
 
-// ParseJWTToken parse tokenString and return data if token is valid
-func ParseJWTToken(secret []byte, tokenString string) (map[string]any, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return secret, nil
-	})
+// ParseJWTTokenWithOptions parse tokenString and return data if token is
+// valid, the issuer and audience are validated against opts as well
+func ParseJWTTokenWithOptions(verifier Verifier, tokenString string, opts *TokenOptions) (map[string]any, error) {
+	claims, err := verifier.Verify(tokenString)
 	if err != nil {
 		return nil, err
 	}
-
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return map[string]any(claims), nil
+	if err := opts.validate(claims); err != nil {
+		return nil, err
 	}
-
-	return nil, errors.New("invalid token")
+	return claims, nil
 }
 
-// Setup setup database tables and create an admin user account
+// Setup migrates database tables and create an admin user account with a
+// generated password
 func Setup(db *sql.DB) (username, password string, err error) {
+	return SetupWithAdmin(db, "", "")
+}
+
+// SetupWithAdmin migrates database tables and create an admin user account
+// with username and password, an empty username defaults to `rest_admin` and
+// an empty password is generated
+func SetupWithAdmin(db *sql.DB, username, password string) (string, string, error) {
+	return setup(db, username, password, defaultLogger{})
+}
+
+func setup(db *sql.DB, username, password string, logger Logger) (string, string, error) {
 	if isSetupDone(db) {
-		err = errors.New("setup is already done before")
-		return
+		return "", "", errors.New("setup is already done before")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	if err := Migrate(ctx, db, Logging(logger)); err != nil {
+		return "", "", err
 	}
-	username, password, err = setupUsers(db)
+	username, password, err := setupUsers(db, username, password, logger)
 	if err != nil {
-		return
+		return "", "", err
 	}
-	err = setupPolicies(db)
-	return
+	return username, password, setupPolicies(db, logger)
 }
 
+// isSetupDone checks whether an admin user exists, tables may exist without
+// an admin user when they are created by Migrate
 func isSetupDone(db *sql.DB) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
 	defer cancel()
-	_, err := db.ExecQuery(ctx, "SELECT 1 FROM auth_users")
-	return err == nil
+	rows, err := db.FetchData(ctx, "SELECT id FROM auth_users WHERE is_admin = true")
+	return err == nil && len(rows) > 0
+}
+
+// toInt64 converts an integer value fetched from database to int64, drivers
+// return different types for the same column type
+func toInt64(v any) int64 {
+	switch i := v.(type) {
+	case int64:
+		return i
+	case float64:
+		return int64(i)
+	case bool:
+		if i {
+			return 1
+		}
+	}
+	return 0
+}
+
+// toBool converts a bool value fetched from database to bool, e.g. MySQL
+// stores bool as TINYINT
+func toBool(v any) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case int64:
+		return b != 0
+	case float64:
+		return b != 0
+	}
+	return false
+}
+
+// toString converts a nullable string value fetched from database to string
+func toString(v any) string {
+	s, _ := v.(string)
+	return s
 }
@@ -17,10 +17,10 @@ func TestJWTToken(t *testing.T) {
 		data := map[string]any{
 			"a": "b",
 		}
-		token, err := GenJWTToken([]byte(testSecret), data)
+		token, err := GenJWTToken(testKey, data)
 		assert.Nil(t, err)
 
-		parsedData, err := ParseJWTToken([]byte(testSecret), token)
+		parsedData, err := ParseJWTToken(testKey, token)
 		assert.Nil(t, err)
 		assert.True(t, reflect.DeepEqual(data, parsedData))
 	})
@@ -29,10 +29,10 @@ func TestJWTToken(t *testing.T) {
 		data := map[string]any{
 			"a": "b",
 		}
-		token, err := GenJWTToken([]byte(testSecret), data)
+		token, err := GenJWTToken(testKey, data)
 		assert.Nil(t, err)
 
-		parsedData, err := ParseJWTToken([]byte(testSecret), token[:len(token)-1])
+		parsedData, err := ParseJWTToken(testKey, token[:len(token)-1])
 		assert.Nil(t, parsedData)
 		assert.NotNil(t, err)
 		t.Log(err)
@@ -43,16 +43,61 @@ func TestJWTToken(t *testing.T) {
 			"a":   "b",
 			"exp": time.Now().Add(-24 * time.Hour).Unix(),
 		}
-		token, err := GenJWTToken([]byte(testSecret), data)
+		token, err := GenJWTToken(testKey, data)
 		assert.Nil(t, err)
 
-		parsedData, err := ParseJWTToken([]byte(testSecret), token)
+		parsedData, err := ParseJWTToken(testKey, token)
 		assert.Nil(t, parsedData)
 		assert.NotNil(t, err)
 		t.Log(err)
 	})
 }
 
+func TestJWTTokenWithOptions(t *testing.T) {
+	opts := &TokenOptions{
+		TTL:      time.Hour,
+		Issuer:   "auth-service",
+		Audience: []string{"orders", "payments"},
+		Leeway:   time.Minute,
+	}
+	claims := opts.registeredClaims(1, time.Now())
+	assert.Equal(t, "1", claims["sub"])
+	token, err := GenJWTToken(testKey, claims)
+	assert.Nil(t, err)
+
+	t.Run("happy path", func(t *testing.T) {
+		_, err := ParseJWTTokenWithOptions(testKey, token, opts)
+		assert.Nil(t, err)
+		_, err = ParseJWTTokenWithOptions(testKey, token, &TokenOptions{Audience: []string{"payments"}})
+		assert.Nil(t, err)
+	})
+
+	t.Run("invalid issuer", func(t *testing.T) {
+		_, err := ParseJWTTokenWithOptions(testKey, token, &TokenOptions{Issuer: "other-service"})
+		assert.NotNil(t, err)
+		t.Log(err)
+	})
+
+	t.Run("invalid audience", func(t *testing.T) {
+		_, err := ParseJWTTokenWithOptions(testKey, token, &TokenOptions{Audience: []string{"users"}})
+		assert.NotNil(t, err)
+		t.Log(err)
+	})
+
+	t.Run("leeway", func(t *testing.T) {
+		data := map[string]any{
+			"exp": time.Now().Add(-30 * time.Second).Unix(),
+			"nbf": time.Now().Add(30 * time.Second).Unix(),
+		}
+		token, err := GenJWTToken(testKey, data)
+		assert.Nil(t, err)
+		_, err = ParseJWTToken(testKey, token)
+		assert.NotNil(t, err)
+		_, err = ParseJWTTokenWithOptions(testKey, token, &TokenOptions{Leeway: time.Minute})
+		assert.Nil(t, err)
+	})
+}
+
 func TestSetup(t *testing.T) {
 	file, err := os.CreateTemp(".", "test-")
 	if err != nil {
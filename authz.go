@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	j "github.com/rest-go/rest/pkg/jsonutil"
+)
+
+// AuthPermissionKey is the context key of the Permission set by the
+// authorization middleware
+const AuthPermissionKey = AuthUserCtxKey("auth-permission")
+
+// Route is the table and the action of a request
+type Route struct {
+	Table  string
+	Action Action
+}
+
+// RouteFunc maps a request to the table and the action to authorize, requests
+// which are not mapped(ok is false) are passed through without authorization
+type RouteFunc func(r *http.Request) (route Route, ok bool)
+
+// RESTRoutes returns the route mapping of rest-go, the path after prefix is
+// `<table>` or `<table>/<primary key>`, and the action is mapped by method:
+//
+//	POST       create
+//	PUT, PATCH update
+//	DELETE     delete
+//	GET        read, or read_mine with the `mine` query
+func RESTRoutes(prefix string) RouteFunc {
+	prefix = "/" + strings.Trim(prefix, "/")
+	return func(r *http.Request) (Route, bool) {
+		path := r.URL.Path
+		if prefix != "/" {
+			if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+				return Route{}, false
+			}
+			path = strings.TrimPrefix(path, prefix)
+		}
+		parts := strings.Split(strings.Trim(path, "/"), "/")
+		if parts[0] == "" || len(parts) > 2 {
+			return Route{}, false
+		}
+
+		route := Route{Table: parts[0]}
+		switch r.Method {
+		case http.MethodPost:
+			route.Action = ActionCreate
+		case http.MethodPut, http.MethodPatch:
+			route.Action = ActionUpdate
+		case http.MethodDelete:
+			route.Action = ActionDelete
+		default:
+			if _, ok := r.URL.Query()["mine"]; ok {
+				route.Action = ActionReadMine
+			} else {
+				route.Action = ActionRead
+			}
+		}
+		return route, true
+	}
+}
+
+// Permission is the result of the authorization middleware, it's stored in
+// the request context and returned by GetPermission
+type Permission struct {
+	Route
+	UserID int64
+	// Filter is set when the policy limits the rows, e.g.
+	// `public or owner_id = auth_user.id`, handlers must filter the rows to
+	// read or write by it
+	Filter *RowFilter
+	// UserIDColumn is set when the policy only limits the rows to the ones
+	// owned by the user, i.e. `<column> = auth_user.id`, the same as Filter
+	UserIDColumn string
+}
+
+// NewAuthzMiddleware creates a middleware which authorizes requests with the
+// policies in store, it must be used after the middleware created by
+// NewMiddleware. Requests are mapped by the RESTRoutes of `/` unless the
+// Routes option is provided. An anonymous user without permission gets a 401
+// response and an authenticated one gets a 403 response.
+func NewAuthzMiddleware(store *PolicyStore, opts ...Option) Middleware {
+	o := newOptions(opts)
+	routes := o.routes
+	if routes == nil {
+		routes = RESTRoutes("/")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, ok := routes(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user := GetUser(r)
+			perm, err := authorize(store, user, route)
+			if err != nil || perm == nil {
+				if err != nil {
+					o.logger.Errorf("authorize request error: %v", err)
+				}
+				if logger, ok := o.logger.(debugLogger); ok {
+					logger.Debugf("permission denied: %s", store.Explain(user, route.Table, route.Action))
+				}
+				if user.IsAnonymous() {
+					j.Write(w, &j.Response{
+						Code: http.StatusUnauthorized,
+						Msg:  "login required",
+					})
+				} else {
+					j.Write(w, &j.Response{
+						Code: http.StatusForbidden,
+						Msg:  "unauthorized",
+					})
+				}
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), AuthPermissionKey, perm)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authorize returns the permission of user on route, nil is returned if it's
+// denied
+func authorize(store *PolicyStore, user *User, route Route) (*Permission, error) {
+	filter, err := store.RowFilter(user, route.Table, route.Action)
+	if err != nil {
+		return nil, err
+	}
+	if !filter.Allowed() {
+		return nil, nil
+	}
+	perm := &Permission{Route: route, UserID: user.ID}
+	if filter.All() {
+		return perm, nil
+	}
+	perm.Filter = filter
+	if e, ok := filter.expr.(*compareExpr); ok {
+		if column, ok := userIDColumn(e); ok {
+			// an anonymous user doesn't own any rows
+			if user.IsAnonymous() {
+				return nil, nil
+			}
+			perm.UserIDColumn = column
+		}
+	}
+	return perm, nil
+}
+
+// GetPermission returns the permission in request context, nil is returned
+// if the request is not authorized by the authorization middleware
+func GetPermission(r *http.Request) *Permission {
+	if perm, ok := r.Context().Value(AuthPermissionKey).(*Permission); ok {
+		return perm
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	j "github.com/rest-go/rest/pkg/jsonutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRESTRoutes(t *testing.T) {
+	tests := []struct {
+		prefix string
+		method string
+		path   string
+		route  Route
+		ok     bool
+	}{
+		{"/", http.MethodGet, "/articles", Route{"articles", ActionRead}, true},
+		{"/", http.MethodGet, "/articles?mine", Route{"articles", ActionReadMine}, true},
+		{"/", http.MethodPost, "/articles", Route{"articles", ActionCreate}, true},
+		{"/", http.MethodPut, "/articles/1", Route{"articles", ActionUpdate}, true},
+		{"/", http.MethodPatch, "/articles/1", Route{"articles", ActionUpdate}, true},
+		{"/", http.MethodDelete, "/articles/1", Route{"articles", ActionDelete}, true},
+		{"/", http.MethodGet, "/", Route{}, false},
+		{"/", http.MethodGet, "/articles/1/comments", Route{}, false},
+		{"/api/", http.MethodGet, "/api/articles", Route{"articles", ActionRead}, true},
+		{"/api/", http.MethodGet, "/apis/articles", Route{}, false},
+		{"/api/", http.MethodGet, "/articles", Route{}, false},
+	}
+	for _, tt := range tests {
+		route, ok := RESTRoutes(tt.prefix)(httptest.NewRequest(tt.method, tt.path, nil))
+		assert.Equal(t, tt.ok, ok, "%s %s", tt.method, tt.path)
+		assert.Equal(t, tt.route, route, "%s %s", tt.method, tt.path)
+	}
+}
+
+func TestAuthzMiddleware(t *testing.T) {
+	store := NewPolicyStore(testHandler.db, -1)
+	defer store.Close()
+
+	var perm *Permission
+	handler := NewAuthzMiddleware(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		perm = GetPermission(r)
+		j.Write(w, &j.Response{Code: http.StatusOK, Msg: "success"})
+	}))
+	request := func(method, path string, user *User) (int, string) {
+		perm = nil
+		req := httptest.NewRequest(method, path, nil)
+		if user != nil {
+			req = req.WithContext(context.WithValue(req.Context(), AuthUserKey, user))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		var res j.Response
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return w.Code, res.Msg
+	}
+
+	t.Run("login required", func(t *testing.T) {
+		code, msg := request(http.MethodGet, "/todos", nil)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Equal(t, "login required", msg)
+		assert.Nil(t, perm)
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		code, msg := request(http.MethodDelete, "/auth_users/1", &User{ID: 2})
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Equal(t, "unauthorized", msg)
+		assert.Nil(t, perm)
+	})
+
+	t.Run("owned rows", func(t *testing.T) {
+		code, _ := request(http.MethodGet, "/todos?mine", &User{ID: 2})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, Route{"todos", ActionReadMine}, perm.Route)
+		assert.Equal(t, int64(2), perm.UserID)
+		assert.Equal(t, "user_id", perm.UserIDColumn)
+		assert.Equal(t, "user_id = 2", perm.Filter.String())
+	})
+
+	t.Run("row filter", func(t *testing.T) {
+		ctx := context.Background()
+		_, err := testHandler.db.ExecQuery(ctx, createInternalPolicy, "public articles", "articles", "read", "public or owner_id = auth_user.id", "", "")
+		assert.Nil(t, err)
+		defer func() {
+			_, err := testHandler.db.ExecQuery(ctx, "DELETE FROM auth_policies WHERE table_name = 'articles'")
+			assert.Nil(t, err)
+			store.Invalidate()
+		}()
+		store.Invalidate()
+
+		code, _ := request(http.MethodGet, "/articles", &User{ID: 2})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "", perm.UserIDColumn)
+		assert.Equal(t, "(public or owner_id = 2)", perm.Filter.String())
+		assert.True(t, perm.Filter.Match(map[string]any{"public": true, "owner_id": 3}))
+		assert.False(t, perm.Filter.Match(map[string]any{"public": false, "owner_id": 3}))
+
+		// anonymous users can read public articles
+		code, _ = request(http.MethodGet, "/articles", nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.NotNil(t, perm.Filter)
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		code, _ := request(http.MethodGet, "/auth_users", &User{ID: 1, IsAdmin: true})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, &Permission{Route: Route{"auth_users", ActionRead}, UserID: 1}, perm)
+		assert.Nil(t, perm.Filter)
+	})
+
+	t.Run("explain denials with debug logging", func(t *testing.T) {
+		logger := &testDebugLogger{}
+		handler := NewAuthzMiddleware(store, Logging(logger))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		req := httptest.NewRequest(http.MethodDelete, "/auth_users/1", nil)
+		req = req.WithContext(context.WithValue(req.Context(), AuthUserKey, &User{ID: 2}))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		assert.Len(t, logger.debugs, 1)
+		assert.Contains(t, logger.debugs[0], "permission denied: deny user 2 to delete on auth_users")
+	})
+
+	t.Run("not mapped", func(t *testing.T) {
+		code, _ := request(http.MethodGet, "/", nil)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Nil(t, perm)
+	})
+
+	t.Run("custom routes", func(t *testing.T) {
+		publish := MustRegisterAction("publish")
+		handler := NewAuthzMiddleware(store, Routes(func(r *http.Request) (Route, bool) {
+			return Route{"auth_policies", publish}, true
+		}))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		req := httptest.NewRequest(http.MethodPost, "/publish", nil)
+		req = req.WithContext(context.WithValue(req.Context(), AuthUserKey, &User{ID: 2}))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+type testDebugLogger struct {
+	defaultLogger
+	debugs []string
+}
+
+func (l *testDebugLogger) Debugf(format string, v ...any) {
+	l.debugs = append(l.debugs, fmt.Sprintf(format, v...))
+}
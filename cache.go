@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCacheItems is the number of items to trigger a purge of expired items
+const maxCacheItems = 10000
+
+type cacheItem[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+// ttlCache is a concurrency safe in-memory cache, each item expires after ttl
+type ttlCache[K comparable, V any] struct {
+	mu    sync.RWMutex
+	ttl   time.Duration
+	items map[K]cacheItem[V]
+}
+
+func newTTLCache[K comparable, V any](ttl time.Duration) *ttlCache[K, V] {
+	return &ttlCache[K, V]{ttl: ttl, items: map[K]cacheItem[V]{}}
+}
+
+// get returns the value and whether it's found and not expired
+func (c *ttlCache[K, V]) get(key K) (value V, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	item, ok := c.items[key]
+	if !ok || time.Now().After(item.expiresAt) {
+		return value, false
+	}
+	return item.value, true
+}
+
+// set caches the value for ttl, or until expiresAt if it's earlier
+func (c *ttlCache[K, V]) set(key K, value V, expiresAt time.Time) {
+	now := time.Now()
+	if deadline := now.Add(c.ttl); expiresAt.IsZero() || expiresAt.After(deadline) {
+		expiresAt = deadline
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.items) >= maxCacheItems {
+		for k, item := range c.items {
+			if now.After(item.expiresAt) {
+				delete(c.items, k)
+			}
+		}
+	}
+	c.items[key] = cacheItem[V]{value, expiresAt}
+}
+
+// delete removes the value from cache
+func (c *ttlCache[K, V]) delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, key)
+}
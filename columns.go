@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var columnExp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ColumnSet is the set of columns a policy permits, it permits all the
+// columns except the denied ones if no column is allowed explicitly
+type ColumnSet struct {
+	allowed map[string]struct{}
+	denied  map[string]struct{}
+}
+
+// NewColumnSet returns a ColumnSet which permits the allowed columns except
+// the denied ones, an empty allowed permits all the columns
+func NewColumnSet(allowed, denied []string) *ColumnSet {
+	c := &ColumnSet{denied: make(map[string]struct{}, len(denied))}
+	if len(allowed) > 0 {
+		c.allowed = make(map[string]struct{}, len(allowed))
+		for _, column := range allowed {
+			c.allowed[column] = struct{}{}
+		}
+	}
+	for _, column := range denied {
+		c.denied[column] = struct{}{}
+	}
+	return c
+}
+
+// All returns true if all the columns are permitted
+func (c *ColumnSet) All() bool {
+	return c.allowed == nil && len(c.denied) == 0
+}
+
+// Permits returns whether the column is permitted
+func (c *ColumnSet) Permits(column string) bool {
+	if _, ok := c.denied[column]; ok {
+		return false
+	}
+	if c.allowed == nil {
+		return true
+	}
+	_, ok := c.allowed[column]
+	return ok
+}
+
+// Filter returns the permitted columns in columns, e.g. to build the select
+// list of a query
+func (c *ColumnSet) Filter(columns []string) []string {
+	permitted := make([]string, 0, len(columns))
+	for _, column := range columns {
+		if c.Permits(column) {
+			permitted = append(permitted, column)
+		}
+	}
+	return permitted
+}
+
+// Strip deletes the columns not permitted from row, e.g. a row to be returned
+func (c *ColumnSet) Strip(row map[string]any) {
+	for column := range row {
+		if !c.Permits(column) {
+			delete(row, column)
+		}
+	}
+}
+
+// Forbidden returns the sorted columns in row which are not permitted, e.g. to
+// reject the row to be written
+func (c *ColumnSet) Forbidden(row map[string]any) []string {
+	var forbidden []string
+	for column := range row {
+		if !c.Permits(column) {
+			forbidden = append(forbidden, column)
+		}
+	}
+	sort.Strings(forbidden)
+	return forbidden
+}
+
+// PermittedColumns returns whether user has permission to perform action on
+// the table and the columns permitted, the policy is chosen and evaluated the
+// same as HasPerm, the rows must be limited by withUserIDColumn if it's not
+// empty
+func (u *User) PermittedColumns(table string, action Action, policies []Policy) (hasPerm bool, withUserIDColumn string, columns *ColumnSet) {
+	if policies == nil {
+		return u.permittedColumns(table, action, nil, defaultLogger{})
+	}
+	return u.permittedColumns(table, action, indexPolicies(policies), defaultLogger{})
+}
+
+func (u *User) permittedColumns(table string, action Action, index map[string]map[string]*Policy, logger Logger) (bool, string, *ColumnSet) {
+	if index == nil {
+		logger.Warnf("nil policies")
+		return false, "", NewColumnSet(nil, nil)
+	}
+	policy, ok := lookupPolicy(table, action, index, nil)
+	if !ok {
+		return true, "", NewColumnSet(nil, nil)
+	}
+	hasPerm, withUserIDColumn := u.hasPerm(policy.Expression, logger)
+	return hasPerm, withUserIDColumn, policy.Columns()
+}
+
+// indexPolicies indexes policies by table name and action
+func indexPolicies(policies []Policy) map[string]map[string]*Policy {
+	index := make(map[string]map[string]*Policy)
+	for i := range policies {
+		p := &policies[i]
+		if _, ok := index[p.TableName]; !ok {
+			index[p.TableName] = make(map[string]*Policy)
+		}
+		index[p.TableName][p.Action] = p
+	}
+	return index
+}
+
+// joinColumns joins columns to be stored in a policy row
+func joinColumns(columns []string) string {
+	return strings.Join(columns, ",")
+}
+
+// splitColumns splits the columns stored in a policy row
+func splitColumns(s string) []string {
+	if s == "" {
+		return nil
+	}
+	columns := strings.Split(s, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+	}
+	return columns
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestColumnSet(t *testing.T) {
+	all := NewColumnSet(nil, nil)
+	assert.True(t, all.All())
+	assert.True(t, all.Permits("password"))
+
+	columns := NewColumnSet([]string{"id", "username", "password"}, []string{"password"})
+	assert.False(t, columns.All())
+	assert.True(t, columns.Permits("username"))
+	assert.False(t, columns.Permits("password"))
+	assert.False(t, columns.Permits("is_admin"))
+	assert.Equal(t, []string{"id", "username"}, columns.Filter([]string{"id", "username", "password", "is_admin"}))
+
+	row := map[string]any{"id": 1, "username": "hello", "password": "hash", "is_admin": true}
+	assert.Equal(t, []string{"is_admin", "password"}, columns.Forbidden(row))
+	columns.Strip(row)
+	assert.Equal(t, map[string]any{"id": 1, "username": "hello"}, row)
+}
+
+func TestUser_PermittedColumns(t *testing.T) {
+	policies := []Policy{
+		{TableName: "auth_users", Action: "read", Expression: "auth_user.is_authenticated", DeniedColumns: []string{"password"}},
+		{TableName: "auth_users", Action: "update", Expression: "id = auth_user.id", AllowedColumns: []string{"username"}},
+		{TableName: "auth_users", Action: "all", Expression: "auth_user.is_admin"},
+	}
+
+	user := &User{ID: 1}
+	hasPerm, userIDColumn, columns := user.PermittedColumns("auth_users", ActionRead, policies)
+	assert.True(t, hasPerm)
+	assert.Equal(t, "", userIDColumn)
+	assert.False(t, columns.Permits("password"))
+	assert.True(t, columns.Permits("is_admin"))
+
+	// the rows are limited by the user id column
+	hasPerm, userIDColumn, columns = user.PermittedColumns("auth_users", ActionUpdate, policies)
+	assert.True(t, hasPerm)
+	assert.Equal(t, "id", userIDColumn)
+	assert.Equal(t, []string{"is_admin"}, columns.Forbidden(map[string]any{"username": "a", "is_admin": true}))
+	hasPerm, _, _ = (&User{}).PermittedColumns("auth_users", ActionUpdate, policies)
+	assert.False(t, hasPerm)
+
+	hasPerm, _, _ = user.PermittedColumns("auth_users", ActionDelete, policies)
+	assert.False(t, hasPerm)
+
+	hasPerm, userIDColumn, columns = user.PermittedColumns("todos", ActionDelete, policies)
+	assert.True(t, hasPerm)
+	assert.Equal(t, "", userIDColumn)
+	assert.True(t, columns.All())
+
+	hasPerm, _, _ = user.PermittedColumns("todos", ActionDelete, nil)
+	assert.False(t, hasPerm)
+
+	t.Run("validate", func(t *testing.T) {
+		policy := &Policy{TableName: "todos", Action: "read", Expression: "true", DeniedColumns: []string{"secret"}}
+		assert.Nil(t, policy.Validate())
+		policy.AllowedColumns = []string{"id; DROP TABLE todos"}
+		assert.NotNil(t, policy.Validate())
+	})
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	j "github.com/rest-go/rest/pkg/jsonutil"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// the purpose of email verification tokens
+	purposeEmailVerification = "email_verification"
+
+	// resendVerificationMessage is responded whether the user exists or not
+	resendVerificationMessage = "an email verification token is sent if the email of the user is not verified"
+)
+
+// fetchUserByLogin fetches the user by email if it's provided, or by
+// username. A username containing `@` is looked up as an email first, usernames
+// registered since emails are supported can't contain `@`.
+func (h *Handler) fetchUserByLogin(ctx context.Context, username, email string) (map[string]any, error) {
+	if email != "" {
+		return h.fetchUserByEmail(ctx, email)
+	}
+	if !strings.Contains(username, "@") {
+		return h.db.FetchOne(ctx, queryUser, username)
+	}
+	row, dbErr := h.fetchUserByEmail(ctx, username)
+	var sqlErr sql.Error
+	if dbErr != nil && errors.As(dbErr, &sqlErr) && sqlErr.Code == http.StatusNotFound {
+		return h.db.FetchOne(ctx, queryUser, username)
+	}
+	return row, dbErr
+}
+
+// fetchUserByEmail fetches the user by email case insensitively
+func (h *Handler) fetchUserByEmail(ctx context.Context, email string) (map[string]any, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, sql.NewError(http.StatusNotFound, err.Error())
+	}
+	return h.db.FetchOne(ctx, queryUserByEmail, email)
+}
+
+// checkEmailAvailable returns a 409 response if email is used by another user
+// as email or username
+func (h *Handler) checkEmailAvailable(ctx context.Context, email string) any {
+	for _, query := range []string{queryUserByEmail, queryUser} {
+		_, dbErr := h.db.FetchOne(ctx, query, email)
+		if dbErr == nil {
+			return &j.Response{
+				Code: http.StatusConflict,
+				Msg:  "email already exists",
+			}
+		}
+		var sqlErr sql.Error
+		if !errors.As(dbErr, &sqlErr) || sqlErr.Code != http.StatusNotFound {
+			h.options.logger.Errorf("fetch user error: %v", dbErr)
+			return j.ErrResponse(dbErr)
+		}
+	}
+	return nil
+}
+
+// sendEmailVerification sends an email verification token to the user by the
+// notifier, it does nothing without a notifier
+func (h *Handler) sendEmailVerification(ctx context.Context, user *User) error {
+	if h.options.notifier == nil {
+		return nil
+	}
+	expiresAt := time.Now().Add(h.options.token.VerifyTTL)
+	token, err := issueOneTimeToken(ctx, h.db, user.ID, purposeEmailVerification, expiresAt)
+	if err != nil {
+		return err
+	}
+	return h.options.notifier.Notify(ctx, &Notification{
+		Purpose:   purposeEmailVerification,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// verifyEmail marks the email of the user as verified with an email
+// verification token
+func (h *Handler) verifyEmail(r *http.Request) any {
+	if h.options.notifier == nil {
+		return &j.Response{
+			Code: http.StatusNotFound,
+			Msg:  "action not found",
+		}
+	}
+	var data struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.Token == "" {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data, token is required",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	id, userID, err := checkOneTimeToken(ctx, h.db, data.Token, purposeEmailVerification)
+	if err == nil {
+		err = useToken(ctx, h.db, id)
+	}
+	if errors.Is(err, errInvalidOneTimeToken) {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
+	}
+	if err != nil {
+		h.options.logger.Errorf("check email verification token error: %v", err)
+		return j.ErrResponse(err)
+	}
+	if _, dbErr := h.db.ExecQuery(ctx, verifyUserEmail, userID); dbErr != nil {
+		h.options.logger.Errorf("verify user email error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
+
+// resendEmailVerification sends a new email verification token to the user by
+// the notifier, e.g. after the previous one expired, the user is found by
+// username or email. Like forgotPassword, the token is issued and sent in
+// background, so that the response doesn't reveal whether the user exists.
+func (h *Handler) resendEmailVerification(r *http.Request) any {
+	if h.options.notifier == nil {
+		return &j.Response{
+			Code: http.StatusNotFound,
+			Msg:  "action not found",
+		}
+	}
+	var data struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || (data.Username == "" && data.Email == "") {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data, username or email is required",
+		}
+	}
+
+	h.tasks.Add(1)
+	go func() {
+		defer h.tasks.Done()
+		if err := h.resendEmailVerificationTo(data.Username, data.Email); err != nil {
+			h.options.logger.Errorf("resend email verification error: %v", err)
+		}
+	}()
+	return &j.Response{Code: http.StatusOK, Msg: resendVerificationMessage}
+}
+
+// resendEmailVerificationTo sends an email verification token to the user of
+// username or email, nothing is sent if the user doesn't exist or the email is
+// verified already
+func (h *Handler) resendEmailVerificationTo(username, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	row, dbErr := h.fetchUserByLogin(ctx, username, email)
+	if dbErr != nil {
+		var sqlErr sql.Error
+		if errors.As(dbErr, &sqlErr) && sqlErr.Code == http.StatusNotFound {
+			return nil
+		}
+		return dbErr
+	}
+	user := newUserFromRow(row)
+	if user.Email == "" || user.EmailVerified {
+		return nil
+	}
+	return h.sendEmailVerification(ctx, user)
+}
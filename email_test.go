@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	email, err := normalizeEmail(" Hello@Example.COM ")
+	assert.Nil(t, err)
+	assert.Equal(t, "hello@example.com", email)
+
+	for _, invalid := range []string{"hello", "hello@", "Hello <hello@example.com>", strings.Repeat("a", 250) + "@example.com"} {
+		_, err := normalizeEmail(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestHandlerEmail(t *testing.T) {
+	notifier := NewMemoryNotifier()
+	handler, err := New(DB(testHandler.db), SigningKey(testKey), Notifications(notifier), RequireVerifiedEmail())
+	assert.Nil(t, err)
+	defer handler.Close()
+
+	t.Run("register", func(t *testing.T) {
+		status, msg := postMsg(t, handler, "/auth/register", `{"username": "email_user", "password": "world"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, "email is required", msg)
+		status, _ = postMsg(t, handler, "/auth/register", `{"username": "email_user", "password": "world", "email": "invalid"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, _ = postMsg(t, handler, "/auth/register", `{"username": "email_user", "password": "world", "email": "Email_User@Example.com"}`)
+		assert.Equal(t, http.StatusOK, status)
+		n := notifier.Last("email_user")
+		assert.NotNil(t, n)
+		assert.Equal(t, purposeEmailVerification, n.Purpose)
+		assert.Equal(t, "email_user@example.com", n.Email)
+
+		// emails are unique case insensitively
+		status, _ = postMsg(t, handler, "/auth/register", `{"username": "email_user2", "password": "world", "email": "EMAIL_USER@example.com"}`)
+		assert.Equal(t, http.StatusConflict, status)
+		// a username can't take the email of another user
+		status, msg = postMsg(t, handler, "/auth/register", `{"username": "email_user@example.com", "password": "world", "email": "other@example.com"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, "username can't contain @", msg)
+	})
+
+	t.Run("legacy username with @", func(t *testing.T) {
+		hashed, err := HashPassword("world")
+		assert.Nil(t, err)
+		_, err = testHandler.db.ExecQuery(context.Background(), createUser, "legacy@example.com", hashed, nil)
+		assert.Nil(t, err)
+		status, _ := postMsg(t, handler, "/auth/register", `{"username": "legacy_user", "password": "world", "email": "legacy@example.com"}`)
+		assert.Equal(t, http.StatusConflict, status)
+		status, _ = postMsg(t, handler, "/auth/login", `{"username": "legacy@example.com", "password": "world"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = postMsg(t, handler, "/auth/login", `{"email": "legacy@example.com", "password": "world"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("users without email", func(t *testing.T) {
+		// the handler without RequireVerifiedEmail registers users without email
+		status, _ := serve(t, testHandler, http.MethodPost, "/auth/register", "", `{"username": "no_email_user", "password": "world"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/register", "", `{"username": "no_email_user2", "password": "world"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = postMsg(t, handler, "/auth/login", `{"username": "no_email_user", "password": "world"}`)
+		assert.Equal(t, http.StatusOK, status)
+	})
+
+	t.Run("resend", func(t *testing.T) {
+		first := notifier.Last("email_user")
+		assert.NotNil(t, first)
+		status, _ := postMsg(t, handler, "/auth/email/resend", `{}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		sent := len(notifier.Notifications())
+		status, msg := postMsg(t, handler, "/auth/email/resend", `{"username": "unknown_user"}`)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, resendVerificationMessage, msg)
+		// users without email get nothing
+		status, _ = postMsg(t, handler, "/auth/email/resend", `{"username": "no_email_user"}`)
+		assert.Equal(t, http.StatusOK, status)
+		// the token is sent in background
+		handler.tasks.Wait()
+		assert.Len(t, notifier.Notifications(), sent)
+
+		for i, body := range []string{`{"username": "email_user"}`, `{"email": "Email_User@example.com"}`} {
+			status, msg := postMsg(t, handler, "/auth/email/resend", body)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, resendVerificationMessage, msg)
+			handler.tasks.Wait()
+			assert.Len(t, notifier.Notifications(), sent+i+1)
+			n := notifier.Last("email_user")
+			assert.Equal(t, purposeEmailVerification, n.Purpose)
+			assert.NotEqual(t, first.Token, n.Token)
+		}
+		// a new token expires the previous ones
+		status, _ = postMsg(t, handler, "/auth/email/verify", `{"token": "`+first.Token+`"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		status, msg := postMsg(t, handler, "/auth/login", `{"username": "email_user", "password": "world"}`)
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Equal(t, "email is not verified", msg)
+
+		n := notifier.Last("email_user")
+		assert.NotNil(t, n)
+		status, _ = postMsg(t, handler, "/auth/email/verify", `{"token": "invalid"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _ = postMsg(t, handler, "/auth/email/verify", `{"token": "`+n.Token+`"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = postMsg(t, handler, "/auth/email/verify", `{"token": "`+n.Token+`"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, res := serve(t, handler, http.MethodPost, "/auth/login", "", `{"username": "email_user", "password": "world"}`)
+		assert.Equal(t, http.StatusOK, status)
+		data, err := ParseJWTToken(testKey, decodeResponse(t, res)["token"].(string))
+		assert.Nil(t, err)
+		user, err := newUserFromClaims(data, nil)
+		assert.Nil(t, err)
+		assert.True(t, user.EmailVerified)
+
+		// nothing is sent once the email is verified
+		sent := len(notifier.Notifications())
+		status, _ = postMsg(t, handler, "/auth/email/resend", `{"username": "email_user"}`)
+		assert.Equal(t, http.StatusOK, status)
+		handler.tasks.Wait()
+		assert.Len(t, notifier.Notifications(), sent)
+	})
+
+	t.Run("login by email", func(t *testing.T) {
+		status, _ := postMsg(t, handler, "/auth/login", `{"username": "EMAIL_USER@example.com", "password": "world"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = postMsg(t, handler, "/auth/login", `{"email": "email_user@example.com", "password": "world"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = postMsg(t, handler, "/auth/login", `{"email": "email_user@example.com", "password": "wrong"}`)
+		assert.Equal(t, http.StatusUnauthorized, status)
+	})
+
+	t.Run("forgot password by email", func(t *testing.T) {
+		sent := len(notifier.Notifications())
+		for i, body := range []string{`{"username": "email_user@example.com"}`, `{"email": "Email_User@example.com"}`} {
+			status, _ := postMsg(t, handler, "/auth/password/forgot", body)
+			assert.Equal(t, http.StatusOK, status)
+			// the token is sent in background
+			handler.tasks.Wait()
+			assert.Len(t, notifier.Notifications(), sent+i+1)
+			n := notifier.Last("email_user")
+			assert.NotNil(t, n)
+			assert.Equal(t, purposePasswordReset, n.Purpose)
+			assert.Equal(t, "email_user@example.com", n.Email)
+		}
+	})
+
+	t.Run("disabled without notifier", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodPost, "/auth/email/verify", "", `{"token": "token"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/email/resend", "", `{"username": "email_user"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+}
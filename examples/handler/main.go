@@ -19,11 +19,11 @@ func handle(w http.ResponseWriter, req *http.Request) {
 func main() {
 	dbURL := "sqlite://my.db"
 	jwtSecret := "my secret"
-	authHandler, err := auth.NewHandler(dbURL, []byte(jwtSecret))
+	authHandler, err := auth.NewHandler(dbURL, auth.NewHMACKey([]byte(jwtSecret)))
 	if err != nil {
 		log.Fatal(err)
 	}
-	middleware := auth.NewMiddleware([]byte(jwtSecret))
+	middleware := auth.NewMiddleware(auth.NewHMACKey([]byte(jwtSecret)))
 
 	http.Handle("/auth/", authHandler)
 	http.Handle("/", middleware(http.HandlerFunc(handle)))
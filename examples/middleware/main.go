@@ -18,7 +18,7 @@ func handle(w http.ResponseWriter, req *http.Request) {
 
 func main() {
 	jwtSecret := "my secret"
-	middleware := auth.NewMiddleware([]byte(jwtSecret))
+	middleware := auth.NewMiddleware(auth.NewHMACKey([]byte(jwtSecret)))
 
 	http.Handle("/", middleware(http.HandlerFunc(handle)))
 	log.Fatal(http.ListenAndServe(":8000", nil)) //nolint:gosec
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Decision explains a permission check, it records the policy matched, the
+// lookups made to find it and the reason of the result. It can be returned by
+// debug endpoints or written to audit logs.
+type Decision struct {
+	UserID           int64    `json:"user_id"`
+	Table            string   `json:"table"`
+	Action           string   `json:"action"`
+	Allowed          bool     `json:"allowed"`
+	WithUserIDColumn string   `json:"with_user_id_column,omitempty"`
+	Policy           *Policy  `json:"policy,omitempty"`
+	Path             []string `json:"path"`
+	// Residual is the expression left after evaluating the user values, it
+	// only depends on the columns of the table
+	Residual string `json:"residual,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// String returns a one line summary of the decision
+func (d *Decision) String() string {
+	result := "deny"
+	if d.Allowed {
+		result = "allow"
+	}
+	policy := "none"
+	if d.Policy != nil {
+		policy = fmt.Sprintf("#%d %s.%s %q", d.Policy.ID, d.Policy.TableName, d.Policy.Action, d.Policy.Expression)
+	}
+	return fmt.Sprintf("%s user %d to %s on %s, policy: %s, path: [%s], reason: %s",
+		result, d.UserID, d.Action, d.Table, policy, strings.Join(d.Path, "; "), d.Reason)
+}
+
+// Explain checks whether user has permission to perform action on the table
+// like HasPerm, and returns how the decision is made
+func (u *User) Explain(table string, action Action, policies []Policy) *Decision {
+	var index map[string]map[string]*Policy
+	if policies != nil {
+		index = indexPolicies(policies)
+	}
+	return u.explain(table, action, index, defaultLogger{})
+}
+
+func (u *User) explain(table string, action Action, index map[string]map[string]*Policy, logger Logger) *Decision {
+	d := &Decision{
+		UserID: u.ID,
+		Table:  table,
+		Action: action.String(),
+		Path:   []string{},
+	}
+	if index == nil {
+		d.Reason = "nil policies"
+		return d
+	}
+
+	policy, ok := lookupPolicy(table, action, index, &d.Path)
+	exp := ""
+	if ok {
+		p := *policy
+		d.Policy = &p
+		exp = p.Expression
+	}
+	hasPerm, column, residual, reason := u.decide(exp, logger)
+	d.Allowed = hasPerm
+	d.WithUserIDColumn = column
+	if _, ok := residual.(*literalExpr); !ok && residual != nil {
+		d.Residual = residual.String()
+	}
+	if !ok {
+		reason = "no policy matched, allowed by default"
+	}
+	d.Reason = reason
+	return d
+}
+
+// Explain checks whether user has permission to perform action on the table
+// with the stored policies, and returns how the decision is made
+func (s *PolicyStore) Explain(user *User, table string, action Action) *Decision {
+	s.load()
+	s.mu.RLock()
+	index := s.index
+	s.mu.RUnlock()
+	return user.explain(table, action, index, s.logger)
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	policies := []Policy{
+		{ID: 1, TableName: "articles", Action: "delete", Expression: "auth_user.is_admin"},
+		{ID: 2, TableName: "articles", Action: "all", Expression: "user_id = auth_user.id"},
+		{ID: 3, TableName: "comments", Action: "read", Expression: "published = true and auth_user.id > 0"},
+		{ID: 4, TableName: "all", Action: "all", Expression: "auth_user.is_admin"},
+	}
+	user := &User{ID: 1}
+
+	t.Run("bulk falls back to base action", func(t *testing.T) {
+		d := user.Explain("articles", ActionBulkDelete, policies)
+		assert.False(t, d.Allowed)
+		assert.Equal(t, int64(1), d.Policy.ID)
+		assert.Equal(t, []string{"articles.bulk_delete: not found", "articles.delete: matched"}, d.Path)
+		assert.Equal(t, "expression is false for the user", d.Reason)
+	})
+
+	t.Run("user id column", func(t *testing.T) {
+		d := user.Explain("articles", ActionUpdate, policies)
+		assert.True(t, d.Allowed)
+		assert.Equal(t, "user_id", d.WithUserIDColumn)
+		assert.Equal(t, int64(2), d.Policy.ID)
+		assert.Equal(t, []string{"articles.update: not found", "articles.all: matched"}, d.Path)
+		assert.Equal(t, "user_id = 1", d.Residual)
+
+		d = (&User{}).Explain("articles", ActionUpdate, policies)
+		assert.False(t, d.Allowed)
+		assert.Contains(t, d.Reason, "login required")
+	})
+
+	t.Run("residual on other columns", func(t *testing.T) {
+		d := user.Explain("comments", ActionRead, policies)
+		assert.False(t, d.Allowed)
+		assert.Equal(t, "published = true", d.Residual)
+		assert.Contains(t, d.Reason, "row filter")
+	})
+
+	t.Run("table all", func(t *testing.T) {
+		d := (&User{ID: 1, IsAdmin: true}).Explain("todos", ActionRead, policies)
+		assert.True(t, d.Allowed)
+		assert.Equal(t, int64(4), d.Policy.ID)
+		assert.Equal(t, []string{"todos: no policies, fall back to table all", "all.read: not found", "all.all: matched"}, d.Path)
+
+		d = user.Explain("comments", ActionCreate, policies)
+		assert.False(t, d.Allowed)
+		assert.Equal(t, []string{"comments.create: not found", "comments.all: not found", "all.all: matched"}, d.Path)
+	})
+
+	t.Run("no policy", func(t *testing.T) {
+		d := user.Explain("todos", ActionRead, []Policy{})
+		assert.True(t, d.Allowed)
+		assert.Nil(t, d.Policy)
+		assert.Equal(t, "no policy matched, allowed by default", d.Reason)
+
+		d = user.Explain("todos", ActionRead, nil)
+		assert.False(t, d.Allowed)
+		assert.Equal(t, "nil policies", d.Reason)
+	})
+
+	t.Run("same as HasPerm", func(t *testing.T) {
+		perms := map[string]map[string]string{}
+		for _, p := range policies {
+			if perms[p.TableName] == nil {
+				perms[p.TableName] = map[string]string{}
+			}
+			perms[p.TableName][p.Action] = p.Expression
+		}
+		for _, u := range []*User{{}, {ID: 1}, {ID: 2, IsAdmin: true}} {
+			for _, table := range []string{"articles", "comments", "todos"} {
+				for _, action := range []Action{ActionCreate, ActionRead, ActionUpdate, ActionDelete, ActionBulkDelete} {
+					hasPerm, column := u.HasPerm(table, action, perms)
+					d := u.Explain(table, action, policies)
+					assert.Equal(t, hasPerm, d.Allowed, "%s.%s", table, action)
+					assert.Equal(t, column, d.WithUserIDColumn, "%s.%s", table, action)
+				}
+			}
+		}
+	})
+
+	t.Run("audit log", func(t *testing.T) {
+		d := user.Explain("articles", ActionDelete, policies)
+		assert.Equal(t, `deny user 1 to delete on articles, policy: #1 articles.delete "auth_user.is_admin", path: [articles.delete: matched], reason: expression is false for the user`, d.String())
+		data, err := json.Marshal(d)
+		assert.Nil(t, err)
+		assert.Contains(t, string(data), `"path":["articles.delete: matched"]`)
+	})
+
+	t.Run("store", func(t *testing.T) {
+		store := NewPolicyStore(testHandler.db, -1)
+		defer store.Close()
+		d := store.Explain(user, "auth_users", ActionRead)
+		assert.False(t, d.Allowed)
+		assert.Equal(t, "auth_users", d.Policy.TableName)
+		assert.Equal(t, []string{"password"}, d.Policy.DeniedColumns)
+	})
+}
@@ -0,0 +1,724 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Policy expressions are boolean expressions evaluated against the request
+// user and the rows of a table, e.g.
+//
+//	auth_user.is_admin or (user_id = auth_user.id and status in ('draft', 'published'))
+//
+// The supported syntax is
+//
+//   - logical operators: `and`, `or`, `not` and parentheses
+//   - comparisons: `=`, `==`, `!=`, `<>`, `<`, `<=`, `>`, `>=`, `in (...)` and `not in (...)`
+//   - literals: 'strings', "strings", numbers, `true`, `false` and `null`
+//   - user references: `auth_user.id`, `auth_user.username`, `auth_user.is_admin`,
+//     `auth_user.is_authenticated`, `auth_user.is_anonymous` and custom claims
+//     as `auth_user.claims.<name>`
+//   - user functions: `auth_user.has_role('<name>')` and `auth_user.in_group('<name>')`
+//   - column references: any other identifier is a column of the table
+//
+// An empty expression allows, the same as no policy. A stored policy must have
+// an expression though, PolicyStore denies the action of a policy which fails
+// Policy.Validate.
+
+const userRefPrefix = "auth_user"
+
+var userFields = map[string]struct{}{
+	"id":               {},
+	"username":         {},
+	"is_admin":         {},
+	"is_authenticated": {},
+	"is_anonymous":     {},
+}
+
+// userFunc is a function of the request user, it takes string literal args
+// and returns a bool
+type userFunc struct {
+	args int
+	call func(u *User, args []string) bool
+}
+
+var userFuncs = map[string]userFunc{
+	"has_role": {args: 1, call: func(u *User, args []string) bool { return u.HasRole(args[0]) }},
+	"in_group": {args: 1, call: func(u *User, args []string) bool { return u.InGroup(args[0]) }},
+}
+
+// ValidateExpression returns an error if exp is not a valid policy expression,
+// the parsed expression isn't cached
+func ValidateExpression(exp string) error {
+	_, err := parse(exp)
+	return err
+}
+
+// maxCachedExpressions bounds exprCache, the cache is cleared when it's full
+// so that it can't grow with expressions which are no longer used
+const maxCachedExpressions = 1024
+
+// exprCache caches parsed expressions by their source, policies are checked on
+// every request while they rarely change
+var exprCache = struct {
+	sync.RWMutex
+	exprs map[string]expr
+}{exprs: make(map[string]expr)}
+
+// parseExpression parses exp into an expression tree with exprCache, an empty
+// exp is parsed as a true literal
+func parseExpression(exp string) (expr, error) {
+	exprCache.RLock()
+	e, ok := exprCache.exprs[exp]
+	exprCache.RUnlock()
+	if ok {
+		return e, nil
+	}
+	e, err := parse(exp)
+	if err != nil {
+		return nil, err
+	}
+	exprCache.Lock()
+	defer exprCache.Unlock()
+	if len(exprCache.exprs) >= maxCachedExpressions {
+		exprCache.exprs = make(map[string]expr)
+	}
+	exprCache.exprs[exp] = e
+	return e, nil
+}
+
+// parse parses exp into an expression tree
+func parse(exp string) (expr, error) {
+	if strings.TrimSpace(exp) == "" {
+		return &literalExpr{value: true}, nil
+	}
+	tokens, err := lex(exp)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+	}
+	return e, nil
+}
+
+// expr is a node of the expression tree
+type expr interface {
+	String() string
+}
+
+// literalExpr is a constant value, ref is the user reference it's evaluated
+// from, if any
+type literalExpr struct {
+	value any
+	ref   string
+}
+
+// userExpr is a reference to a field or a custom claim of the request user
+type userExpr struct {
+	field string
+	claim string
+}
+
+// callExpr is a call to a function of the request user
+type callExpr struct {
+	name string
+	args []string
+}
+
+// columnExpr is a reference to a column of the table
+type columnExpr struct {
+	name string
+}
+
+type notExpr struct {
+	x expr
+}
+
+// logicalExpr is an `and` or `or` expression
+type logicalExpr struct {
+	op          string
+	left, right expr
+}
+
+type compareExpr struct {
+	op          string
+	left, right expr
+}
+
+type inExpr struct {
+	x    expr
+	list []expr
+	not  bool
+}
+
+func (e *literalExpr) String() string {
+	switch v := e.value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (e *userExpr) String() string {
+	if e.claim != "" {
+		return userRefPrefix + ".claims." + e.claim
+	}
+	return userRefPrefix + "." + e.field
+}
+
+func (e *callExpr) String() string {
+	args := make([]string, len(e.args))
+	for i, arg := range e.args {
+		args[i] = (&literalExpr{value: arg}).String()
+	}
+	return userRefPrefix + "." + e.name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (e *columnExpr) String() string { return e.name }
+
+func (e *notExpr) String() string { return "not " + e.x.String() }
+
+func (e *logicalExpr) String() string {
+	return "(" + e.left.String() + " " + e.op + " " + e.right.String() + ")"
+}
+
+func (e *compareExpr) String() string {
+	return e.left.String() + " " + e.op + " " + e.right.String()
+}
+
+func (e *inExpr) String() string {
+	items := make([]string, len(e.list))
+	for i, item := range e.list {
+		items[i] = item.String()
+	}
+	op := " in "
+	if e.not {
+		op = " not in "
+	}
+	return e.x.String() + op + "(" + strings.Join(items, ", ") + ")"
+}
+
+// eval evaluates e against user, the result is a literal if e doesn't
+// reference any column, otherwise it's the remaining expression on columns
+// with user references replaced by literals
+func eval(e expr, user *User) expr {
+	switch e := e.(type) {
+	case *literalExpr, *columnExpr:
+		return e
+	case *userExpr:
+		return &literalExpr{value: user.field(e), ref: e.String()}
+	case *callExpr:
+		return &literalExpr{value: userFuncs[e.name].call(user, e.args), ref: e.String()}
+	case *notExpr:
+		x := eval(e.x, user)
+		if l, ok := x.(*literalExpr); ok {
+			return &literalExpr{value: !truthy(l.value)}
+		}
+		return &notExpr{x: x}
+	case *logicalExpr:
+		return evalLogical(e, user)
+	case *compareExpr:
+		left, right := eval(e.left, user), eval(e.right, user)
+		l, lok := left.(*literalExpr)
+		r, rok := right.(*literalExpr)
+		if lok && rok {
+			return &literalExpr{value: compare(e.op, l.value, r.value)}
+		}
+		return &compareExpr{op: e.op, left: left, right: right}
+	case *inExpr:
+		return evalIn(e, user)
+	}
+	return &literalExpr{value: false}
+}
+
+func evalLogical(e *logicalExpr, user *User) expr {
+	// the result if either side is the literal short-circuit value
+	shortCircuit := e.op == "or"
+	left := eval(e.left, user)
+	if l, ok := left.(*literalExpr); ok {
+		if truthy(l.value) == shortCircuit {
+			return &literalExpr{value: shortCircuit}
+		}
+		return eval(e.right, user)
+	}
+	right := eval(e.right, user)
+	if r, ok := right.(*literalExpr); ok {
+		if truthy(r.value) == shortCircuit {
+			return &literalExpr{value: shortCircuit}
+		}
+		return left
+	}
+	return &logicalExpr{op: e.op, left: left, right: right}
+}
+
+func evalIn(e *inExpr, user *User) expr {
+	x := eval(e.x, user)
+	list := make([]expr, len(e.list))
+	allLiterals := true
+	for i, item := range e.list {
+		list[i] = eval(item, user)
+		if _, ok := list[i].(*literalExpr); !ok {
+			allLiterals = false
+		}
+	}
+	l, ok := x.(*literalExpr)
+	if !ok || !allLiterals {
+		return &inExpr{x: x, list: list, not: e.not}
+	}
+	found := false
+	for _, item := range list {
+		if compare("=", l.value, item.(*literalExpr).value) {
+			found = true
+			break
+		}
+	}
+	return &literalExpr{value: found != e.not}
+}
+
+// field returns the value of a user reference
+func (u *User) field(e *userExpr) any {
+	if e.claim != "" {
+		return u.Claim(e.claim)
+	}
+	switch e.field {
+	case "id":
+		return u.ID
+	case "username":
+		return u.Username
+	case "is_admin":
+		return u.IsAdmin
+	case "is_authenticated":
+		return u.IsAuthenticated()
+	case "is_anonymous":
+		return u.IsAnonymous()
+	}
+	return nil
+}
+
+// truthy returns whether a value is considered true as a condition
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+// compare compares two values with op, values of different types are never
+// equal and can't be ordered
+func compare(op string, a, b any) bool {
+	if op == "!=" {
+		return !compare("=", a, b)
+	}
+	var cmp int
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		if !ok {
+			return false
+		}
+		cmp = compareOrdered(fa, fb)
+	} else {
+		switch a := a.(type) {
+		case string:
+			sb, ok := b.(string)
+			if !ok {
+				return false
+			}
+			cmp = compareOrdered(a, sb)
+		case bool:
+			bb, ok := b.(bool)
+			return ok && op == "=" && a == bb
+		case nil:
+			return op == "=" && b == nil
+		default:
+			return false
+		}
+	}
+	switch op {
+	case "=":
+		return cmp == 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func compareOrdered[T float64 | string](a, b T) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// toFloat converts a numeric value to float64, claims decoded from JSON are
+// always float64
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// lex splits exp into tokens
+func lex(exp string) ([]token, error) {
+	var tokens []token
+	runes := []rune(exp)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case r == '\'' || r == '"':
+			s, n, err := lexString(runes[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%w at position %d", err, i)
+			}
+			tokens = append(tokens, token{tokenString, s, i})
+			i += n
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, string(runes[start:i]), start})
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || runes[i] == '.' ||
+				unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, string(runes[start:i]), start})
+		case strings.ContainsRune("=!<>", r):
+			start := i
+			i++
+			if i < len(runes) && strings.ContainsRune("=>", runes[i]) {
+				i++
+			}
+			op := string(runes[start:i])
+			switch op {
+			case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			default:
+				return nil, fmt.Errorf("invalid operator %q at position %d", op, start)
+			}
+			tokens = append(tokens, token{tokenOperator, op, start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", r, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// lexString reads a quoted string, the quote is escaped by doubling it
+func lexString(runes []rune) (string, int, error) {
+	quote := runes[0]
+	var sb strings.Builder
+	for i := 1; i < len(runes); i++ {
+		if runes[i] != quote {
+			sb.WriteRune(runes[i])
+			continue
+		}
+		if i+1 < len(runes) && runes[i+1] == quote {
+			sb.WriteRune(quote)
+			i++
+			continue
+		}
+		return sb.String(), i + 1, nil
+	}
+	return "", 0, errors.New("unterminated string")
+}
+
+// parser is a recursive descent parser of policy expressions
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// keyword returns whether the next token is the keyword, keywords are case
+// insensitive
+func (p *parser) keyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == tokenIdent && strings.EqualFold(tok.text, word)
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.keyword("not") {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (expr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind == tokenOperator {
+		p.next()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		op := tok.text
+		switch op {
+		case "==":
+			op = "="
+		case "<>":
+			op = "!="
+		}
+		return &compareExpr{op: op, left: left, right: right}, nil
+	}
+
+	not := false
+	if p.keyword("not") {
+		// `not` after an operand can only be followed by `in`
+		p.next()
+		if !p.keyword("in") {
+			tok := p.peek()
+			return nil, fmt.Errorf("expect in after not, got %s at position %d", tok, tok.pos)
+		}
+		not = true
+	}
+	if p.keyword("in") {
+		p.next()
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &inExpr{x: left, list: list, not: not}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseList() ([]expr, error) {
+	if tok := p.next(); tok.kind != tokenLParen {
+		return nil, fmt.Errorf("expect ( after in, got %s at position %d", tok, tok.pos)
+	}
+	var list []expr
+	for {
+		item, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+		tok := p.next()
+		if tok.kind == tokenRParen {
+			return list, nil
+		}
+		if tok.kind != tokenComma {
+			return nil, fmt.Errorf("expect , or ) in list, got %s at position %d", tok, tok.pos)
+		}
+	}
+}
+
+func (p *parser) parseOperand() (expr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenLParen:
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.kind != tokenRParen {
+			return nil, fmt.Errorf("expect ), got %s at position %d", tok, tok.pos)
+		}
+		return e, nil
+	case tokenString:
+		return &literalExpr{value: tok.text}, nil
+	case tokenNumber:
+		if n, err := strconv.ParseInt(tok.text, 10, 64); err == nil {
+			return &literalExpr{value: n}, nil
+		}
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at position %d", tok, tok.pos)
+		}
+		return &literalExpr{value: f}, nil
+	case tokenIdent:
+		if p.peek().kind == tokenLParen {
+			return p.parseCall(tok)
+		}
+		return parseIdent(tok)
+	}
+	return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+}
+
+// parseCall parses a call to a user function, e.g. auth_user.has_role('editor')
+func (p *parser) parseCall(tok token) (expr, error) {
+	name := strings.TrimPrefix(tok.text, userRefPrefix+".")
+	f, ok := userFuncs[name]
+	if !ok || name == tok.text {
+		return nil, fmt.Errorf("unknown function %s at position %d", tok, tok.pos)
+	}
+	p.next() // (
+	var args []string
+	for p.peek().kind != tokenRParen {
+		if len(args) > 0 {
+			if sep := p.next(); sep.kind != tokenComma {
+				return nil, fmt.Errorf("expect , or ) in args, got %s at position %d", sep, sep.pos)
+			}
+		}
+		arg := p.next()
+		if arg.kind != tokenString {
+			return nil, fmt.Errorf("expect string arg, got %s at position %d", arg, arg.pos)
+		}
+		args = append(args, arg.text)
+	}
+	p.next() // )
+	if len(args) != f.args {
+		return nil, fmt.Errorf("%s expects %d args, got %d at position %d", name, f.args, len(args), tok.pos)
+	}
+	return &callExpr{name: name, args: args}, nil
+}
+
+// parseIdent parses a keyword literal, a user reference or a column reference
+func parseIdent(tok token) (expr, error) {
+	switch strings.ToLower(tok.text) {
+	case "true":
+		return &literalExpr{value: true}, nil
+	case "false":
+		return &literalExpr{value: false}, nil
+	case "null":
+		return &literalExpr{value: nil}, nil
+	case "and", "or", "not", "in":
+		return nil, fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+	}
+
+	parts := strings.Split(tok.text, ".")
+	if parts[0] != userRefPrefix {
+		if len(parts) > 1 {
+			return nil, fmt.Errorf("unknown reference %s at position %d", tok, tok.pos)
+		}
+		return &columnExpr{name: tok.text}, nil
+	}
+	if len(parts) == 2 {
+		if _, ok := userFields[parts[1]]; ok {
+			return &userExpr{field: parts[1]}, nil
+		}
+	}
+	if len(parts) == 3 && parts[1] == "claims" && parts[2] != "" {
+		return &userExpr{claim: parts[2]}, nil
+	}
+	return nil, fmt.Errorf("unknown user reference %s at position %d", tok, tok.pos)
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateExpression(t *testing.T) {
+	for _, exp := range []string{
+		"",
+		"auth_user.is_admin",
+		"user_id=auth_user.id",
+		"not auth_user.is_anonymous and (status = 'published' or author_id = auth_user.id)",
+		"auth_user.claims.tenant_id = tenant_id",
+		"status not in ('draft', \"archived\")",
+		"score >= 1.5 and level <> -1",
+		"deleted_at = NULL",
+		"auth_user.has_role('editor') or not auth_user.has_role(\"guest\")",
+	} {
+		assert.Nil(t, ValidateExpression(exp), exp)
+	}
+
+	for _, exp := range []string{
+		"invalid policy",
+		"auth_user.password",
+		"auth_user.claims.",
+		"todos.user_id = 1",
+		"user_id = ",
+		"(auth_user.is_admin",
+		"status in 'draft'",
+		"status = 'draft",
+		"user_id => 1",
+		"a not b",
+		"user_id & 1",
+		"has_role('editor')",
+		"auth_user.has_role(editor)",
+		"auth_user.has_role()",
+		"auth_user.has_role('a', 'b')",
+		"auth_user.is_admin()",
+	} {
+		assert.NotNil(t, ValidateExpression(exp), exp)
+	}
+}
+
+func TestEval(t *testing.T) {
+	user := &User{
+		ID:       1,
+		Username: "hello",
+		Roles:    []string{"editor"},
+		Claims:   map[string]any{"tenant_id": float64(7), "plan": "pro"},
+	}
+	for _, test := range []struct {
+		exp  string
+		want string
+	}{
+		{"", "true"},
+		{"auth_user.is_admin", "false"},
+		{"not auth_user.is_admin", "true"},
+		{"auth_user.is_authenticated and auth_user.username = 'hello'", "true"},
+		{"auth_user.id == 1 and auth_user.claims.tenant_id = 7", "true"},
+		{"auth_user.claims.plan in ('free', 'pro')", "true"},
+		{"auth_user.claims.plan not in ('free', 'pro')", "false"},
+		{"auth_user.claims.missing = null", "true"},
+		{"auth_user.id > 0 and auth_user.id <= 1", "true"},
+		{"auth_user.id = '1'", "false"},
+		{"auth_user.id != '1'", "true"},
+		{"auth_user.is_admin or user_id = auth_user.id", "user_id = 1"},
+		{"auth_user.is_authenticated and (public or owner = auth_user.username)", "(public or owner = 'hello')"},
+		{"auth_user.is_anonymous and public", "false"},
+		{"status in ('draft', auth_user.claims.plan)", "status in ('draft', 'pro')"},
+		{"auth_user.has_role('editor') and not auth_user.has_role('admin')", "true"},
+		{"auth_user.has_role('admin') or author_id = auth_user.id", "author_id = 1"},
+	} {
+		e, err := parseExpression(test.exp)
+		assert.Nil(t, err, test.exp)
+		assert.Equal(t, test.want, eval(e, user).String(), test.exp)
+	}
+}
+
+func TestExprCache(t *testing.T) {
+	assert.Nil(t, ValidateExpression("validated_only = 1"))
+	exprCache.RLock()
+	_, ok := exprCache.exprs["validated_only = 1"]
+	exprCache.RUnlock()
+	assert.False(t, ok)
+
+	for i := 0; i <= maxCachedExpressions; i++ {
+		_, err := parseExpression(fmt.Sprintf("id = %d", i))
+		assert.Nil(t, err)
+	}
+	exprCache.RLock()
+	defer exprCache.RUnlock()
+	assert.LessOrEqual(t, len(exprCache.exprs), maxCachedExpressions)
+}
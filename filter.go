@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+// RowFilter is the row-level condition of a policy expression evaluated for a
+// user, the user references are resolved and only the conditions on columns
+// are left, e.g. `public = true or owner_id = auth_user.id` for user 1 is
+// compiled to `("public" = ? OR "owner_id" = ?)` with args [true, 1]
+type RowFilter struct {
+	dialect dialect
+	expr    expr
+}
+
+// CompileRowFilter evaluates the policy expression exp for user and compiles
+// it into a RowFilter for the database driver, one of postgres, mysql and
+// sqlite
+func CompileRowFilter(driver, exp string, user *User) (*RowFilter, error) {
+	d, ok := dialects[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+	e, err := parseExpression(exp)
+	if err != nil {
+		return nil, err
+	}
+	return &RowFilter{dialect: d, expr: eval(e, user)}, nil
+}
+
+// RowFilter returns the filter of the policy on table for action, see HasPerm
+// for how the policy is chosen
+func (u *User) RowFilter(driver, table string, action Action, policies map[string]map[string]string) (*RowFilter, error) {
+	return u.rowFilter(driver, table, action, policies, defaultLogger{})
+}
+
+func (u *User) rowFilter(driver, table string, action Action, policies map[string]map[string]string, logger Logger) (*RowFilter, error) {
+	if policies == nil {
+		logger.Warnf("nil policies")
+		return CompileRowFilter(driver, "false", u)
+	}
+	return CompileRowFilter(driver, policyExpression(table, action, policies), u)
+}
+
+// Allowed returns false if no row is allowed, the action should be denied
+func (f *RowFilter) Allowed() bool {
+	if l, ok := f.expr.(*literalExpr); ok {
+		return truthy(l.value)
+	}
+	return true
+}
+
+// All returns true if all rows are allowed, there is no condition to apply
+func (f *RowFilter) All() bool {
+	if l, ok := f.expr.(*literalExpr); ok {
+		return truthy(l.value)
+	}
+	return false
+}
+
+// WhereQuery returns the condition and args to be appended to a where clause,
+// with `?` placeholders. index is the number of args before the condition and
+// newIndex is the number after it, the same as sql.URLQuery.WhereQuery.
+func (f *RowFilter) WhereQuery(index uint) (newIndex uint, query string, args []any) {
+	c := &filterCompiler{dialect: &f.dialect}
+	c.compile(f.expr)
+	return index + uint(len(c.args)), c.sb.String(), c.args
+}
+
+// SQL returns the condition and args with placeholders of the database driver
+func (f *RowFilter) SQL() (query string, args []any) {
+	_, query, args = f.WhereQuery(0)
+	return sql.Rebind(f.dialect.Driver, query), args
+}
+
+// Match returns whether row satisfies the filter, e.g. the row to be created,
+// a column missing in row is null
+func (f *RowFilter) Match(row map[string]any) bool {
+	e := bindColumns(f.expr, row)
+	l, ok := eval(e, &User{}).(*literalExpr)
+	return ok && truthy(l.value)
+}
+
+// String returns the condition in the policy expression syntax
+func (f *RowFilter) String() string {
+	return f.expr.String()
+}
+
+// bindColumns replaces the column references in e with values in row
+func bindColumns(e expr, row map[string]any) expr {
+	switch e := e.(type) {
+	case *columnExpr:
+		return &literalExpr{value: row[e.name], ref: e.name}
+	case *notExpr:
+		return &notExpr{x: bindColumns(e.x, row)}
+	case *logicalExpr:
+		return &logicalExpr{op: e.op, left: bindColumns(e.left, row), right: bindColumns(e.right, row)}
+	case *compareExpr:
+		// a null user reference never matches, the same as in SQL
+		if isNullUserRef(e.left) || isNullUserRef(e.right) {
+			return &literalExpr{value: false}
+		}
+		return &compareExpr{op: e.op, left: bindColumns(e.left, row), right: bindColumns(e.right, row)}
+	case *inExpr:
+		list := make([]expr, len(e.list))
+		for i, item := range e.list {
+			list[i] = bindColumns(item, row)
+		}
+		return &inExpr{x: bindColumns(e.x, row), list: list, not: e.not}
+	}
+	return e
+}
+
+// filterCompiler compiles an evaluated expression into SQL
+type filterCompiler struct {
+	dialect *dialect
+	sb      strings.Builder
+	args    []any
+}
+
+func (c *filterCompiler) compile(e expr) {
+	switch e := e.(type) {
+	case *literalExpr:
+		// a constant condition
+		if truthy(e.value) {
+			c.sb.WriteString("1 = 1")
+		} else {
+			c.sb.WriteString("1 = 0")
+		}
+	case *columnExpr:
+		c.operand(e)
+	case *notExpr:
+		c.sb.WriteString("NOT (")
+		c.compile(e.x)
+		c.sb.WriteString(")")
+	case *logicalExpr:
+		c.sb.WriteString("(")
+		c.compile(e.left)
+		c.sb.WriteString(" " + strings.ToUpper(e.op) + " ")
+		c.compile(e.right)
+		c.sb.WriteString(")")
+	case *compareExpr:
+		c.compare(e)
+	case *inExpr:
+		c.operand(e.x)
+		if e.not {
+			c.sb.WriteString(" NOT IN (")
+		} else {
+			c.sb.WriteString(" IN (")
+		}
+		for i, item := range e.list {
+			if i > 0 {
+				c.sb.WriteString(", ")
+			}
+			c.operand(item)
+		}
+		c.sb.WriteString(")")
+	}
+}
+
+// compare compiles a comparison, a null literal in the expression is compiled
+// to IS NULL, while a null user reference(e.g. a missing claim) is bound as an
+// arg which never matches
+func (c *filterCompiler) compare(e *compareExpr) {
+	left, right := e.left, e.right
+	if isNullLiteral(left) {
+		left, right = right, left
+	}
+	if isNullLiteral(right) && (e.op == "=" || e.op == "!=") {
+		c.operand(left)
+		if e.op == "=" {
+			c.sb.WriteString(" IS NULL")
+		} else {
+			c.sb.WriteString(" IS NOT NULL")
+		}
+		return
+	}
+	c.operand(e.left)
+	c.sb.WriteString(" " + e.op + " ")
+	c.operand(e.right)
+}
+
+func (c *filterCompiler) operand(e expr) {
+	switch e := e.(type) {
+	case *columnExpr:
+		c.sb.WriteString(c.dialect.Quote + e.name + c.dialect.Quote)
+	case *literalExpr:
+		c.sb.WriteString("?")
+		c.args = append(c.args, e.value)
+	default:
+		// operands are columns or literals after evaluation, a nested
+		// condition is compiled as it is
+		c.sb.WriteString("(")
+		c.compile(e)
+		c.sb.WriteString(")")
+	}
+}
+
+func isNullLiteral(e expr) bool {
+	l, ok := e.(*literalExpr)
+	return ok && l.value == nil && l.ref == ""
+}
+
+func isNullUserRef(e expr) bool {
+	l, ok := e.(*literalExpr)
+	return ok && l.value == nil && strings.HasPrefix(l.ref, userRefPrefix+".")
+}
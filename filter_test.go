@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRowFilter(t *testing.T) {
+	user := &User{ID: 1, Claims: map[string]any{"tenant_id": float64(7)}}
+
+	t.Run("compile", func(t *testing.T) {
+		for _, test := range []struct {
+			driver string
+			exp    string
+			query  string
+			args   []any
+		}{
+			{"sqlite", "", "1 = 1", nil},
+			{"sqlite", "auth_user.is_admin", "1 = 0", nil},
+			{"sqlite", "user_id = auth_user.id", `"user_id" = ?`, []any{int64(1)}},
+			{"mysql", "user_id = auth_user.id", "`user_id` = ?", []any{int64(1)}},
+			{
+				"postgres",
+				"public = true or owner_id = auth_user.id",
+				`("public" = $1 OR "owner_id" = $2)`,
+				[]any{true, int64(1)},
+			},
+			{
+				"postgres",
+				"tenant_id = auth_user.claims.tenant_id and (owner_id = auth_user.id or editor_id = auth_user.id)",
+				`("tenant_id" = $1 AND ("owner_id" = $2 OR "editor_id" = $3))`,
+				[]any{float64(7), int64(1), int64(1)},
+			},
+			{
+				"sqlite",
+				"not archived and deleted_at = null and status not in ('draft', 'hidden')",
+				`((NOT ("archived") AND "deleted_at" IS NULL) AND "status" NOT IN (?, ?))`,
+				[]any{"draft", "hidden"},
+			},
+			{"sqlite", "org_id = auth_user.claims.org_id", `"org_id" = ?`, []any{nil}},
+		} {
+			filter, err := CompileRowFilter(test.driver, test.exp, user)
+			assert.Nil(t, err, test.exp)
+			query, args := filter.SQL()
+			assert.Equal(t, test.query, query, test.exp)
+			assert.Equal(t, test.args, args, test.exp)
+		}
+
+		_, err := CompileRowFilter("oracle", "", user)
+		assert.NotNil(t, err)
+		_, err = CompileRowFilter("sqlite", "invalid policy", user)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		filter, err := CompileRowFilter("sqlite", "auth_user.is_admin", user)
+		assert.Nil(t, err)
+		assert.False(t, filter.Allowed())
+		assert.False(t, filter.All())
+
+		filter, err = CompileRowFilter("sqlite", "auth_user.is_authenticated", user)
+		assert.Nil(t, err)
+		assert.True(t, filter.Allowed())
+		assert.True(t, filter.All())
+
+		filter, err = CompileRowFilter("sqlite", "user_id = auth_user.id", user)
+		assert.Nil(t, err)
+		assert.True(t, filter.Allowed())
+		assert.False(t, filter.All())
+	})
+
+	t.Run("where query", func(t *testing.T) {
+		filter, err := CompileRowFilter("sqlite", "public or owner_id = auth_user.id", user)
+		assert.Nil(t, err)
+		index, query, args := filter.WhereQuery(2)
+		assert.Equal(t, uint(3), index)
+		assert.Equal(t, `("public" OR "owner_id" = ?)`, query)
+		assert.Equal(t, []any{int64(1)}, args)
+	})
+
+	t.Run("match", func(t *testing.T) {
+		filter, err := CompileRowFilter("sqlite", "public = true or owner_id = auth_user.id", user)
+		assert.Nil(t, err)
+		assert.True(t, filter.Match(map[string]any{"public": true, "owner_id": 2}))
+		assert.True(t, filter.Match(map[string]any{"public": false, "owner_id": float64(1)}))
+		assert.False(t, filter.Match(map[string]any{"owner_id": 2}))
+
+		filter, err = CompileRowFilter("sqlite", "org_id = auth_user.claims.org_id", user)
+		assert.Nil(t, err)
+		assert.False(t, filter.Match(map[string]any{}))
+	})
+
+	t.Run("query", func(t *testing.T) {
+		ctx := context.Background()
+		db := testHandler.db
+		_, err := db.ExecQuery(ctx, `CREATE TABLE IF NOT EXISTS test_articles
+			(id INTEGER PRIMARY KEY, owner_id BIGINT, tenant_id BIGINT, public BOOL)`)
+		assert.Nil(t, err)
+		defer db.ExecQuery(ctx, "DROP TABLE test_articles") //nolint:errcheck
+		_, err = db.ExecQuery(ctx, `INSERT INTO test_articles (owner_id, tenant_id, public)
+			VALUES (1, 7, false), (2, 7, true), (2, 7, false), (1, 8, false)`)
+		assert.Nil(t, err)
+
+		policies := map[string]map[string]string{
+			"test_articles": {
+				"read": "tenant_id = auth_user.claims.tenant_id and (public or owner_id = auth_user.id)",
+			},
+		}
+		filter, err := user.RowFilter(db.DriverName, "test_articles", ActionRead, policies)
+		assert.Nil(t, err)
+		query, args := filter.SQL()
+		rows, err := db.FetchData(ctx, fmt.Sprintf("SELECT id FROM test_articles WHERE %s ORDER BY id", query), args...)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(rows))
+	})
+}
@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	j "github.com/rest-go/rest/pkg/jsonutil"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// The name of the groups table
+	GroupTableName = "auth_groups"
+	// The name of the group members table
+	GroupMemberTableName = "auth_group_members"
+	// The name of the group roles table
+	GroupRoleTableName = "auth_group_roles"
+
+	createGroup       = `INSERT INTO auth_groups (name, description, parent_id) VALUES (?, ?, ?)`
+	queryGroups       = `SELECT id, name, description, parent_id FROM auth_groups ORDER BY name`
+	queryGroupByName  = `SELECT id FROM auth_groups WHERE name = ?`
+	queryUserGroups   = `SELECT group_id FROM auth_group_members WHERE user_id = ?`
+	addGroupMember    = `INSERT INTO auth_group_members (group_id, user_id) VALUES (?, ?)`
+	removeGroupMember = `DELETE FROM auth_group_members WHERE group_id = ? AND user_id = ?`
+	grantGroupRole    = `INSERT INTO auth_group_roles (group_id, role_id) VALUES (?, ?)`
+	revokeGroupRole   = `DELETE FROM auth_group_roles WHERE group_id = ? AND role_id = ?`
+	queryGroupRoles   = `
+		SELECT r.name FROM auth_roles r
+		JOIN auth_group_roles gr ON gr.role_id = r.id
+		WHERE gr.group_id IN (%s)
+	`
+)
+
+// Group represents a set of users, e.g. a department or a project. A member
+// of a group is also a member of its parent groups, and has the roles granted
+// to any of these groups. Policies check groups with
+// `auth_user.in_group('<name>')`.
+type Group struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Parent      string `json:"parent,omitempty"`
+}
+
+// Validate returns an error if the group name is invalid
+func (g *Group) Validate() error {
+	if !nameExp.MatchString(g.Name) {
+		return fmt.Errorf("invalid group name %q, only letters, digits and _.:- are allowed", g.Name)
+	}
+	return nil
+}
+
+// fetchGroups returns all the groups, and the parent id of each group by id
+func fetchGroups(ctx context.Context, db *sql.DB) ([]Group, map[int64]int64, error) {
+	rows, dbErr := db.FetchData(ctx, queryGroups)
+	if dbErr != nil {
+		return nil, nil, dbErr
+	}
+	names := make(map[int64]string, len(rows))
+	parents := make(map[int64]int64, len(rows))
+	for _, row := range rows {
+		id := toInt64(row["id"])
+		names[id] = toString(row["name"])
+		parents[id] = toInt64(row["parent_id"])
+	}
+	groups := make([]Group, 0, len(rows))
+	for _, row := range rows {
+		id := toInt64(row["id"])
+		groups = append(groups, Group{
+			ID:          id,
+			Name:        names[id],
+			Description: toString(row["description"]),
+			Parent:      names[parents[id]],
+		})
+	}
+	return groups, parents, nil
+}
+
+// fetchUserAccess returns the names of the roles and groups of user, including
+// the parent groups of the groups the user is a member of, and the roles
+// granted to these groups
+func fetchUserAccess(ctx context.Context, db *sql.DB, userID int64) (roles, groups []string, err error) {
+	roles, err = fetchUserRoles(ctx, db, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, dbErr := db.FetchData(ctx, queryUserGroups, userID)
+	if dbErr != nil {
+		return nil, nil, dbErr
+	}
+	if len(rows) == 0 {
+		return roles, nil, nil
+	}
+
+	allGroups, parents, err := fetchGroups(ctx, db)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make(map[int64]string, len(allGroups))
+	for _, g := range allGroups {
+		names[g.ID] = g.Name
+	}
+	// walk up from each group, the visited check stops cycles as well
+	visited := map[int64]bool{}
+	for _, row := range rows {
+		for id := toInt64(row["group_id"]); id != 0 && !visited[id]; id = parents[id] {
+			visited[id] = true
+		}
+	}
+	ids := make([]any, 0, len(visited))
+	placeholders := make([]string, 0, len(visited))
+	for id := range visited {
+		if name, ok := names[id]; ok {
+			groups = append(groups, name)
+			ids = append(ids, id)
+			placeholders = append(placeholders, "?")
+		}
+	}
+	sort.Strings(groups)
+	if len(ids) == 0 {
+		return roles, groups, nil
+	}
+
+	query := fmt.Sprintf(queryGroupRoles, strings.Join(placeholders, ", "))
+	rows, dbErr = db.FetchData(ctx, query, ids...)
+	if dbErr != nil {
+		return nil, nil, dbErr
+	}
+	for _, row := range rows {
+		roles = append(roles, toString(row["name"]))
+	}
+	return uniqueSorted(roles), groups, nil
+}
+
+func uniqueSorted(items []string) []string {
+	sort.Strings(items)
+	result := items[:0]
+	for i, item := range items {
+		if i == 0 || item != items[i-1] {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// groups serves the admin endpoints of groups
+//
+//	GET  groups               list groups
+//	POST groups               create a group, optionally in a parent group
+//	POST groups/add_member    add a user to a group
+//	POST groups/remove_member remove a user from a group
+//	POST groups/grant_role    grant a role to a group
+//	POST groups/revoke_role   revoke a role from a group
+func (h *Handler) groups(r *http.Request, action string) any {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	if _, res := h.requireAdmin(ctx, r); res != nil {
+		return res
+	}
+
+	switch {
+	case action == "groups" && r.Method == http.MethodGet:
+		groups, _, err := fetchGroups(ctx, h.db)
+		if err != nil {
+			h.options.logger.Errorf("fetch groups error: %v", err)
+			return j.ErrResponse(err)
+		}
+		return groups
+	case action == "groups" && r.Method == http.MethodPost:
+		return h.createGroup(ctx, r)
+	case action == "groups/add_member" && r.Method == http.MethodPost:
+		return h.updateGroupMember(ctx, r, addGroupMember)
+	case action == "groups/remove_member" && r.Method == http.MethodPost:
+		return h.updateGroupMember(ctx, r, removeGroupMember)
+	case action == "groups/grant_role" && r.Method == http.MethodPost:
+		return h.updateGroupRole(ctx, r, grantGroupRole)
+	case action == "groups/revoke_role" && r.Method == http.MethodPost:
+		return h.updateGroupRole(ctx, r, revokeGroupRole)
+	case action == "groups" || action == "groups/add_member" || action == "groups/remove_member" ||
+		action == "groups/grant_role" || action == "groups/revoke_role":
+		return &j.Response{
+			Code: http.StatusMethodNotAllowed,
+			Msg:  fmt.Sprintf("method not supported: %s", r.Method),
+		}
+	}
+	return &j.Response{
+		Code: http.StatusBadRequest,
+		Msg:  "action not supported",
+	}
+}
+
+func (h *Handler) createGroup(ctx context.Context, r *http.Request) any {
+	group := &Group{}
+	if err := json.NewDecoder(r.Body).Decode(group); err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data",
+		}
+	}
+	if err := group.Validate(); err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
+	}
+	// groups can only be created under existing groups, so there is no cycle
+	var parentID any
+	if group.Parent != "" {
+		id, err := fetchGroupID(ctx, h.db, group.Parent)
+		if err != nil {
+			return j.ErrResponse(err)
+		}
+		parentID = id
+	}
+	if _, dbErr := h.db.ExecQuery(ctx, createGroup, group.Name, group.Description, parentID); dbErr != nil {
+		h.options.logger.Errorf("create group error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
+
+// updateGroupMember adds or removes a group member with query, the change
+// takes effect when the user gets a new token
+func (h *Handler) updateGroupMember(ctx context.Context, r *http.Request, query string) any {
+	var data struct {
+		Group  string `json:"group"`
+		UserID int64  `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.Group == "" || data.UserID == 0 {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data, group and user_id are required",
+		}
+	}
+	groupID, err := fetchGroupID(ctx, h.db, data.Group)
+	if err != nil {
+		return j.ErrResponse(err)
+	}
+	if _, dbErr := h.db.FetchOne(ctx, queryUserByID, data.UserID); dbErr != nil {
+		return j.ErrResponse(dbErr)
+	}
+	if _, dbErr := h.db.ExecQuery(ctx, query, groupID, data.UserID); dbErr != nil {
+		h.options.logger.Errorf("update group member error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
+
+// updateGroupRole grants or revokes a group role with query
+func (h *Handler) updateGroupRole(ctx context.Context, r *http.Request, query string) any {
+	var data struct {
+		Group string `json:"group"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.Group == "" || data.Role == "" {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data, group and role are required",
+		}
+	}
+	groupID, err := fetchGroupID(ctx, h.db, data.Group)
+	if err != nil {
+		return j.ErrResponse(err)
+	}
+	roleID, err := fetchRoleID(ctx, h.db, data.Role)
+	if err != nil {
+		return j.ErrResponse(err)
+	}
+	if _, dbErr := h.db.ExecQuery(ctx, query, groupID, roleID); dbErr != nil {
+		h.options.logger.Errorf("update group role error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
+
+// fetchGroupID returns the id of the group by name
+func fetchGroupID(ctx context.Context, db *sql.DB, name string) (int64, error) {
+	row, dbErr := db.FetchOne(ctx, queryGroupByName, name)
+	if dbErr != nil {
+		return 0, dbErr
+	}
+	return toInt64(row["id"]), nil
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerGroups(t *testing.T) {
+	status, _ := serve(t, testHandler, http.MethodPost, "/auth/register", "", `{"username": "group_user", "password": "world"}`)
+	assert.Equal(t, http.StatusOK, status)
+	userToken := login(t, "group_user", "world")["token"]
+	userData, err := ParseJWTToken(testKey, userToken)
+	assert.Nil(t, err)
+	userID := strconv.FormatInt(toInt64(userData["user_id"]), 10)
+	token := adminToken(t)
+
+	t.Run("admin required", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodPost, "/auth/groups", userToken, `{"name": "engineering"}`)
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodPost, "/auth/groups", token, `{"name": "engineering"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/groups", token, `{"name": "backend", "parent": "engineering"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/groups", token, `{"name": "frontend", "parent": "design"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/groups", token, `{"name": "a b"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, data := serve(t, testHandler, http.MethodGet, "/auth/groups", token, "")
+		assert.Equal(t, http.StatusOK, status)
+		var groups []Group
+		assert.Nil(t, json.Unmarshal(data, &groups))
+		assert.Equal(t, 2, len(groups))
+		assert.Equal(t, "backend", groups[0].Name)
+		assert.Equal(t, "engineering", groups[0].Parent)
+	})
+
+	t.Run("inherit", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodPost, "/auth/roles", token, `{"name": "deployer"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/groups/grant_role", token, `{"group": "engineering", "role": "deployer"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/groups/add_member", token, `{"group": "backend", "user_id": `+userID+`}`)
+		assert.Equal(t, http.StatusOK, status)
+
+		data, err := ParseJWTToken(testKey, login(t, "group_user", "world")["token"])
+		assert.Nil(t, err)
+		user, err := newUserFromClaims(data, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"backend", "engineering"}, user.Groups)
+		assert.Equal(t, []string{"deployer"}, user.Roles)
+
+		policies := map[string]map[string]string{
+			"deployments": {
+				"create": "auth_user.in_group('engineering') and auth_user.has_role('deployer')",
+				"delete": "auth_user.in_group('frontend')",
+			},
+		}
+		hasPerm, _ := user.HasPerm("deployments", ActionCreate, policies)
+		assert.True(t, hasPerm)
+		hasPerm, _ = user.HasPerm("deployments", ActionDelete, policies)
+		assert.False(t, hasPerm)
+
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/groups/revoke_role", token, `{"group": "engineering", "role": "deployer"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/groups/remove_member", token, `{"group": "backend", "user_id": `+userID+`}`)
+		assert.Equal(t, http.StatusOK, status)
+		data, err = ParseJWTToken(testKey, login(t, "group_user", "world")["token"])
+		assert.Nil(t, err)
+		assert.Nil(t, data["groups"])
+		assert.Nil(t, data["roles"])
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodGet, "/auth/groups/add_member", token, "")
+		assert.Equal(t, http.StatusMethodNotAllowed, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/groups/x", token, "")
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
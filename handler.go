@@ -2,86 +2,313 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	j "github.com/rest-go/rest/pkg/jsonutil"
-	"github.com/rest-go/rest/pkg/log"
 	"github.com/rest-go/rest/pkg/sql"
-	"golang.org/x/crypto/bcrypt"
 )
 
-const adminUsername = "rest_admin"
+const (
+	adminUsername    = "rest_admin"
+	jwksAction       = ".well-known/jwks.json"
+	setupTokenHeader = "X-Setup-Token"
+)
+
+// jwksProvider is implemented by keys which can publish their public keys,
+// e.g. Key and KeySet
+type jwksProvider interface {
+	JWKS() *JWKS
+}
 
 // Handler is handler with auth endpoints like `register`, `login`, and `logout`
 type Handler struct {
-	db     *sql.DB
-	secret []byte
+	db           *sql.DB
+	ownsDB       bool // whether the db is opened by Handler
+	ownsPolicies bool // whether the policy store is created by Handler
+	key          SignerVerifier
+	options      *options
+	tasks        sync.WaitGroup // background tasks, Close waits for them
 }
 
-// NewHandler return a Handler with provided database url and JWT secret
-func NewHandler(dbURL string, secret []byte) (*Handler, error) {
-	db, err := sql.Open(dbURL)
-	if err != nil {
-		return nil, err
+// NewHandler return a Handler with provided database url and JWT key, the key
+// is used to sign tokens, see NewHMACKey and NewKey for how to create one
+func NewHandler(dbURL string, key SignerVerifier, opts ...Option) (*Handler, error) {
+	return New(append([]Option{DBURL(dbURL), SigningKey(key)}, opts...)...)
+}
+
+// NewHandlerWithDB return a Handler which shares the opened database, the
+// database is not closed by Handler.Close
+func NewHandlerWithDB(db *sql.DB, key SignerVerifier, opts ...Option) (*Handler, error) {
+	return New(append([]Option{DB(db), SigningKey(key)}, opts...)...)
+}
+
+// New return a Handler configured by options, a database(DB or DBURL) and a
+// SigningKey are required
+func New(opts ...Option) (*Handler, error) {
+	o := newOptions(opts)
+	if o.key == nil {
+		return nil, errors.New("signing key is required")
+	}
+	if o.requireVerifiedEmail && o.notifier == nil {
+		// no user could verify the email to log in
+		return nil, errors.New("notifications are required to verify emails")
+	}
+	db, ownsDB := o.db, false
+	if db == nil {
+		if o.dbURL == "" {
+			return nil, errors.New("database is required")
+		}
+		var err error
+		db, err = sql.Open(o.dbURL)
+		if err != nil {
+			return nil, err
+		}
+		ownsDB = true
+	}
+	if o.setupToken == "" {
+		o.setupToken = os.Getenv(SetupTokenEnv)
+	}
+	if o.revocations == nil {
+		o.revocations = NewRevocationStore(db, 0, Logging(o.logger))
+	}
+	ownsPolicies := false
+	if o.policies == nil {
+		// the policies are reloaded after they're changed by Handler, there
+		// is no polling goroutine to leak if Handler is not closed
+		o.policies = NewPolicyStore(db, -1, Logging(o.logger))
+		ownsPolicies = true
 	}
-	return &Handler{db, secret}, nil
+	return &Handler{
+		db:           db,
+		ownsDB:       ownsDB,
+		ownsPolicies: ownsPolicies,
+		key:          o.key,
+		options:      o,
+	}, nil
+}
+
+// Close releases the resources held by Handler after the background tasks are
+// done, the database and the policy store are closed only if they're created
+// by Handler
+func (h *Handler) Close() error {
+	h.tasks.Wait()
+	if h.ownsPolicies {
+		h.options.policies.Close()
+	}
+	if h.ownsDB {
+		return h.db.Close()
+	}
+	return nil
+}
+
+// RevocationStore returns the store where Handler writes revoked tokens, pass
+// it to NewMiddleware with the Revocations option to reject revoked tokens
+func (h *Handler) RevocationStore() RevocationStore {
+	return h.options.revocations
+}
+
+// PolicyStore returns the store of policies used by Handler, use it to check
+// permissions with the policies in database
+func (h *Handler) PolicyStore() *PolicyStore {
+	return h.options.policies
 }
 
 // ServeHTTP implements http.Handler interface
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.URL.Path, h.options.prefix)
+	if action == jwksAction && r.Method == http.MethodGet {
+		h.writeResponse(w, h.jwks())
+		return
+	}
+	// admin resources support other methods than POST
+	switch resource, _, _ := strings.Cut(action, "/"); resource {
+	case "roles":
+		h.writeResponse(w, h.roles(r, action))
+		return
+	case "groups":
+		h.writeResponse(w, h.groups(r, action))
+		return
+	case "policies":
+		h.writeResponse(w, h.policies(r, action))
+		return
+	case "users":
+		h.writeResponse(w, h.users(r, action))
+		return
+	}
+
 	if r.Method != http.MethodPost {
 		res := &j.Response{
 			Code: http.StatusMethodNotAllowed,
 			Msg:  fmt.Sprintf("method not supported: %s", r.Method),
 		}
-		j.Write(w, res)
+		h.writeResponse(w, res)
 		return
 	}
 
-	action := strings.TrimPrefix(r.URL.Path, "/auth/")
 	if action == "" {
 		res := &j.Response{
 			Code: http.StatusBadRequest,
 			Msg:  "no auth action provided",
 		}
-		j.Write(w, res)
+		h.writeResponse(w, res)
 		return
 	}
 
 	var res any
 	switch action {
 	case "setup":
-		res = h.setup()
+		res = h.setup(r)
 	case "register":
 		res = h.register(r)
 	case "login":
 		res = h.login(r)
+	case "refresh":
+		res = h.refresh(r)
 	case "logout":
 		res = h.logout(r)
+	case "logout_all":
+		res = h.logoutAll(r)
+	case "password/change":
+		res = h.changePassword(r)
+	case "password/forgot":
+		res = h.forgotPassword(r)
+	case "password/reset":
+		res = h.resetForgottenPassword(r)
+	case "email/verify":
+		res = h.verifyEmail(r)
+	case "email/resend":
+		res = h.resendEmailVerification(r)
 	default:
 		res = &j.Response{
 			Code: http.StatusBadRequest,
 			Msg:  "action not supported",
 		}
 	}
-	j.Write(w, res)
+	h.writeResponse(w, res)
+}
+
+// validationResponse is a 400 response listing the violated rules
+type validationResponse struct {
+	j.Response
+	Errors []Violation `json:"errors"`
+}
+
+func (r *validationResponse) statusCode() int {
+	return r.Code
+}
+
+// statusResponse is a response carrying its status other than *j.Response
+type statusResponse interface {
+	statusCode() int
 }
 
-func (h *Handler) setup() any {
-	username, password, err := Setup(h.db)
+// newValidationResponse returns a 400 response of the validation error err,
+// with the violations if err is a *ValidationError
+func newValidationResponse(err error) any {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return &validationResponse{
+			Response: j.Response{Code: http.StatusBadRequest, Msg: err.Error()},
+			Errors:   verr.Violations,
+		}
+	}
+	return &j.Response{
+		Code: http.StatusBadRequest,
+		Msg:  err.Error(),
+	}
+}
+
+// writeResponse writes res like j.Write, with the status of a *j.Response or
+// a statusResponse, other values are written with 200
+func (h *Handler) writeResponse(w http.ResponseWriter, res any) {
+	code := http.StatusOK
+	switch r := res.(type) {
+	case *j.Response:
+		code = r.Code
+	case statusResponse:
+		code = r.statusCode()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		h.options.logger.Errorf("failed to encode json data, %v", err)
+	}
+}
+
+func (h *Handler) jwks() any {
+	if p, ok := h.key.(jwksProvider); ok {
+		return p.JWKS()
+	}
+	return &JWKS{Keys: []JWK{}}
+}
+
+// setup migrates tables and creates the admin user, the endpoint is gone once
+// setup is done or if it's disabled
+func (h *Handler) setup(r *http.Request) any {
+	if h.options.disableSetup || isSetupDone(h.db) {
+		return &j.Response{
+			Code: http.StatusNotFound,
+			Msg:  "action not found",
+		}
+	}
+	if h.options.setupToken != "" {
+		token := r.Header.Get(setupTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(h.options.setupToken)) != 1 {
+			h.options.logger.Warnf("setup with invalid token from %s", r.RemoteAddr)
+			return &j.Response{
+				Code: http.StatusUnauthorized,
+				Msg:  "invalid setup token",
+			}
+		}
+	}
+
+	admin := &User{
+		Username: h.options.adminUsername,
+		Password: h.options.adminPassword,
+	}
+	if r.Body != nil && r.ContentLength != 0 {
+		var data User
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			return &j.Response{
+				Code: http.StatusBadRequest,
+				Msg:  "failed to decode json data",
+			}
+		}
+		if data.Username != "" {
+			admin.Username = data.Username
+		}
+		if data.Password != "" {
+			admin.Password = data.Password
+		}
+	}
+	if admin.Password != "" && h.options.passwordValidator != nil {
+		if err := h.options.passwordValidator(admin.Username, admin.Password); err != nil {
+			return newValidationResponse(err)
+		}
+	}
+
+	username, password, err := setup(h.db, admin.Username, admin.Password, h.options.logger)
 	if err != nil {
-		log.Error("setup error: ", err)
+		h.options.logger.Errorf("setup error: %v", err)
 		return j.ErrResponse(err)
 	}
+	h.options.policies.Invalidate()
+	// don't echo back a provided password
+	if admin.Password != "" {
+		password = ""
+	}
 
 	return &struct {
 		Username string
-		Password string
+		Password string `json:",omitempty"`
 	}{
 		Username: username,
 		Password: password,
@@ -97,22 +324,73 @@ func (h *Handler) register(r *http.Request) any {
 			Msg:  "failed to decode json data",
 		}
 	}
+	if h.options.passwordValidator != nil {
+		if err := h.options.passwordValidator(user.Username, user.Password); err != nil {
+			return newValidationResponse(err)
+		}
+	}
+	// a username can't be taken for the email of another user on login
+	if strings.Contains(user.Username, "@") {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "username can't contain @",
+		}
+	}
+	// users without email are stored with a NULL email to keep emails unique
+	var email any
+	if user.Email != "" {
+		if user.Email, err = normalizeEmail(user.Email); err != nil {
+			return &j.Response{
+				Code: http.StatusBadRequest,
+				Msg:  err.Error(),
+			}
+		}
+		email = user.Email
+	} else if h.options.requireVerifiedEmail {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "email is required",
+		}
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
 	defer cancel()
-	hashedPassword, err := HashPassword(user.Password)
+	if user.Email != "" {
+		if res := h.checkEmailAvailable(ctx, user.Email); res != nil {
+			return res
+		}
+	}
+	hashedPassword, err := h.options.hasher.Hash(user.Password)
 	if err != nil {
 		return &j.Response{
 			Code: http.StatusInternalServerError,
 			Msg:  "failed to hash password",
 		}
 	}
-	_, dbErr := h.db.ExecQuery(ctx, createUser, user.Username, hashedPassword)
+	_, dbErr := h.db.ExecQuery(ctx, createUser, user.Username, hashedPassword, email)
 	if dbErr != nil {
-		log.Errorf("create user error: %v", dbErr)
+		h.options.logger.Errorf("create user error: %v", dbErr)
 		return j.ErrResponse(dbErr)
 	}
 
+	if h.options.afterRegister != nil || user.Email != "" {
+		row, dbErr := h.db.FetchOne(ctx, queryUser, user.Username)
+		if dbErr != nil {
+			h.options.logger.Errorf("fetch user error: %v", dbErr)
+			return j.ErrResponse(dbErr)
+		}
+		user = newUserFromRow(row)
+	}
+	if user.Email != "" {
+		// a new token can be requested by the email/resend endpoint, so a
+		// failure doesn't fail the registration
+		if err := h.sendEmailVerification(ctx, user); err != nil {
+			h.options.logger.Errorf("send email verification error: %v", err)
+		}
+	}
+	if h.options.afterRegister != nil {
+		h.options.afterRegister(ctx, user)
+	}
 	return &j.Response{Code: http.StatusOK, Msg: "success"}
 }
 
@@ -120,17 +398,17 @@ func (h *Handler) login(r *http.Request) any {
 	user := &User{}
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
-		log.Warnf("failed to parse json data: %v", err)
+		h.options.logger.Warnf("failed to parse json data: %v", err)
 		return &j.Response{
 			Code: http.StatusBadRequest,
 			Msg:  fmt.Sprintf("failed to parse post json data, %v", err),
 		}
 	}
 
-	// authenticate the user by input username and password
-	user, err = h.authenticate(user.Username, user.Password)
+	// authenticate the user by input username or email and password
+	user, err = h.authenticate(user.Username, user.Email, user.Password)
 	if err != nil {
-		log.Errorf("authenticate user error: %v", err)
+		h.options.logger.Errorf("authenticate user error: %v", err)
 		var dbErr sql.Error
 		if errors.As(err, &dbErr) {
 			return j.ErrResponse(dbErr)
@@ -142,11 +420,107 @@ func (h *Handler) login(r *http.Request) any {
 		}
 	}
 
-	tokenString, err := GenJWTToken(h.secret, map[string]any{
-		"user_id":  user.ID,
-		"is_admin": user.IsAdmin,
-		"exp":      time.Now().Add(14 * 24 * time.Hour).Unix(),
-	})
+	if h.options.requireVerifiedEmail && user.Email != "" && !user.EmailVerified {
+		return &j.Response{
+			Code: http.StatusForbidden,
+			Msg:  "email is not verified",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	res := h.issueTokens(ctx, user, "")
+	if _, ok := res.(*tokenResponse); ok && h.options.afterLogin != nil {
+		h.options.afterLogin(ctx, user)
+	}
+	return res
+}
+
+func (h *Handler) refresh(r *http.Request) any {
+	var data struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil || data.RefreshToken == "" {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data, refresh_token is required",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	userID, family, err := rotateRefreshToken(ctx, h.db, h.options.logger, data.RefreshToken)
+	if err != nil {
+		h.options.logger.Warnf("refresh token error: %v", err)
+		var dbErr sql.Error
+		if errors.As(err, &dbErr) {
+			return j.ErrResponse(dbErr)
+		}
+		return &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  fmt.Sprintf("failed to refresh token, %v", err),
+		}
+	}
+
+	row, dbErr := h.db.FetchOne(ctx, queryUserByID, userID)
+	if dbErr != nil {
+		h.options.logger.Errorf("fetch user error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+	return h.issueTokens(ctx, newUserFromRow(row), family)
+}
+
+// issueTokens issues a short-lived access token and a refresh token in the
+// family for user
+func (h *Handler) issueTokens(ctx context.Context, user *User, family string) any {
+	version, err := h.options.revocations.TokenVersion(ctx, user.ID)
+	if err != nil {
+		h.options.logger.Errorf("fetch token version error: %v", err)
+		return j.ErrResponse(err)
+	}
+	user.Roles, user.Groups, err = fetchUserAccess(ctx, h.db, user.ID)
+	if err != nil {
+		h.options.logger.Errorf("fetch user roles and groups error: %v", err)
+		return j.ErrResponse(err)
+	}
+	jti, err := genToken()
+	if err != nil {
+		return j.ErrResponse(err)
+	}
+	claims := map[string]any{}
+	if h.options.claimsBuilder != nil {
+		custom, err := h.options.claimsBuilder(ctx, user)
+		if err != nil {
+			h.options.logger.Errorf("build claims error: %v", err)
+			return j.ErrResponse(err)
+		}
+		// custom claims can't grant roles, groups or anything set below
+		for k, v := range custom {
+			if _, ok := reservedClaims[k]; ok {
+				h.options.logger.Warnf("ignore reserved claim from claims builder: %s", k)
+				continue
+			}
+			claims[k] = v
+		}
+	}
+	for k, v := range h.options.token.registeredClaims(user.ID, time.Now()) {
+		claims[k] = v
+	}
+	claims["jti"] = jti
+	claims["user_id"] = user.ID
+	claims["is_admin"] = user.IsAdmin
+	claims["ver"] = version
+	if len(user.Roles) > 0 {
+		claims["roles"] = user.Roles
+	}
+	if len(user.Groups) > 0 {
+		claims["groups"] = user.Groups
+	}
+	if user.Email != "" {
+		claims["email_verified"] = user.EmailVerified
+	}
+	tokenString, err := GenJWTToken(h.key, claims)
 	if err != nil {
 		return &j.Response{
 			Code: http.StatusBadRequest,
@@ -154,34 +528,220 @@ func (h *Handler) login(r *http.Request) any {
 		}
 	}
 
-	return &struct {
-		Token string `json:"token"`
-	}{tokenString}
+	refreshToken, err := issueRefreshToken(ctx, h.db, user.ID, family, h.options.token.RefreshTTL)
+	if err != nil {
+		h.options.logger.Errorf("issue refresh token error: %v", err)
+		return j.ErrResponse(err)
+	}
+
+	return &tokenResponse{Token: tokenString, RefreshToken: refreshToken}
+}
+
+// logout revokes the access token in the header and the refresh token family
+// in the body if provided, the client should delete tokens as well
+func (h *Handler) logout(r *http.Request) any {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+
+	if tokenString := bearerToken(r); tokenString != "" {
+		data, err := parseToken(ctx, h.key, h.options, tokenString)
+		if err != nil {
+			return &j.Response{
+				Code: http.StatusUnauthorized,
+				Msg:  fmt.Sprintf("invalid token, %v", err),
+			}
+		}
+		if jti, ok := data["jti"].(string); ok && jti != "" {
+			expiresAt := time.Unix(toInt64(data["exp"]), 0)
+			if err := h.options.revocations.Revoke(ctx, jti, expiresAt); err != nil {
+				h.options.logger.Errorf("revoke token error: %v", err)
+				return j.ErrResponse(err)
+			}
+		}
+	}
+
+	var data struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err == nil && data.RefreshToken != "" {
+		if err := revokeRefreshToken(ctx, h.db, data.RefreshToken); err != nil {
+			h.options.logger.Errorf("revoke refresh token error: %v", err)
+			return j.ErrResponse(err)
+		}
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
 }
 
-func (h *Handler) logout(_ *http.Request) any {
-	// client delete token, no op on server side
+// logoutAll revokes all the tokens of current user
+func (h *Handler) logoutAll(r *http.Request) any {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+
+	data, err := parseToken(ctx, h.key, h.options, bearerToken(r))
+	if err != nil {
+		return &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  fmt.Sprintf("invalid token, %v", err),
+		}
+	}
+	if err := h.options.revocations.RevokeUser(ctx, toInt64(data["user_id"])); err != nil {
+		h.options.logger.Errorf("revoke user tokens error: %v", err)
+		return j.ErrResponse(err)
+	}
 	return &j.Response{Code: http.StatusOK, Msg: "success"}
 }
 
-func (h *Handler) authenticate(username, password string) (*User, error) {
+// changePassword changes the password of the token user with the current
+// password, all the tokens of the user are revoked and new tokens are issued
+func (h *Handler) changePassword(r *http.Request) any {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	tokenUser, res := h.requireUser(ctx, r)
+	if res != nil {
+		return res
+	}
+
+	var data struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data",
+		}
+	}
+	row, dbErr := h.db.FetchOne(ctx, queryUserPassword, tokenUser.ID)
+	if dbErr != nil {
+		return j.ErrResponse(dbErr)
+	}
+	match, _, err := verifyPassword(h.options.hasher, data.OldPassword, toString(row["password"]))
+	if err != nil {
+		h.options.logger.Errorf("verify password error: %v", err)
+	}
+	if !match {
+		return &j.Response{
+			Code: http.StatusForbidden,
+			Msg:  "current password doesn't match",
+		}
+	}
+
+	user := newUserFromRow(row)
+	if res := h.setPassword(ctx, user, data.NewPassword); res != nil {
+		return res
+	}
+	return h.issueTokens(ctx, user, "")
+}
+
+// setPassword validates and saves the new password of user, and revokes all
+// the tokens of the user including the unused password reset tokens, a
+// response is returned on error
+func (h *Handler) setPassword(ctx context.Context, user *User, password string) any {
+	if h.options.passwordValidator != nil {
+		if err := h.options.passwordValidator(user.Username, password); err != nil {
+			return newValidationResponse(err)
+		}
+	}
+	hashedPassword, err := h.options.hasher.Hash(password)
+	if err != nil {
+		return &j.Response{
+			Code: http.StatusInternalServerError,
+			Msg:  "failed to hash password",
+		}
+	}
+	if _, dbErr := h.db.ExecQuery(ctx, updateUserPassword, hashedPassword, user.ID); dbErr != nil {
+		h.options.logger.Errorf("update password error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+	if err := h.options.revocations.RevokeUser(ctx, user.ID); err != nil {
+		h.options.logger.Errorf("revoke user tokens error: %v", err)
+		return j.ErrResponse(err)
+	}
+	// a reset token requested before mustn't override the new password
+	if _, dbErr := h.db.ExecQuery(ctx, expireOneTimeTokens, user.ID, purposePasswordReset); dbErr != nil {
+		h.options.logger.Errorf("expire password reset tokens error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+	return nil
+}
+
+// requireAdmin authenticates the bearer token in r, a response is returned if
+// the user is not an admin
+func (h *Handler) requireAdmin(ctx context.Context, r *http.Request) (*User, *j.Response) {
+	user, res := h.requireUser(ctx, r)
+	if res != nil {
+		return nil, res
+	}
+	if !user.IsAdmin {
+		return nil, &j.Response{
+			Code: http.StatusForbidden,
+			Msg:  "unauthorized",
+		}
+	}
+	return user, nil
+}
+
+// requireUser authenticates the bearer token in r, a response is returned if
+// there is no valid token
+func (h *Handler) requireUser(ctx context.Context, r *http.Request) (*User, *j.Response) {
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		return nil, &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  "login required",
+		}
+	}
+	data, err := parseToken(ctx, h.key, h.options, tokenString)
+	if err != nil {
+		return nil, &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  fmt.Sprintf("invalid token, %v", err),
+		}
+	}
+	user, err := newUserFromClaims(data, h.options.claimsParser)
+	if err != nil {
+		return nil, &j.Response{
+			Code: http.StatusUnauthorized,
+			Msg:  fmt.Sprintf("invalid token, %v", err),
+		}
+	}
+	return user, nil
+}
+
+// authenticate authenticates the user by username or email, and password
+func (h *Handler) authenticate(username, email, password string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
 	defer cancel()
 
-	row, dbErr := h.db.FetchOne(ctx, queryUser, username)
+	row, dbErr := h.fetchUserByLogin(ctx, username, email)
 	if dbErr != nil {
-		log.Errorf("fetch user error: %v", dbErr)
+		h.options.logger.Errorf("fetch user error: %v", dbErr)
 		return nil, dbErr
 	}
 	hashedPassword := row["password"].(string)
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	match, rehash, err := verifyPassword(h.options.hasher, password, hashedPassword)
 	if err != nil {
+		h.options.logger.Errorf("verify password error: %v", err)
+	}
+	if !match {
 		return nil, errors.New("password doesn't match")
 	}
-	user := &User{
-		ID:       row["id"].(int64),
-		Username: username,
-		IsAdmin:  row["is_admin"].(bool),
+	user := newUserFromRow(row)
+	if rehash {
+		h.rehashPassword(ctx, user.ID, password, hashedPassword)
 	}
 	return user, nil
 }
+
+// rehashPassword replaces the outdated hash of the user's password with the
+// hash of the hasher in use, a failure is only logged since the login is done
+func (h *Handler) rehashPassword(ctx context.Context, userID int64, password, oldHash string) {
+	hashedPassword, err := h.options.hasher.Hash(password)
+	if err != nil {
+		h.options.logger.Errorf("rehash password error: %v", err)
+		return
+	}
+	if _, dbErr := h.db.ExecQuery(ctx, rehashPassword, hashedPassword, userID, oldHash); dbErr != nil {
+		h.options.logger.Errorf("update rehashed password error: %v", dbErr)
+	}
+}
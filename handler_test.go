@@ -1,12 +1,17 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -30,6 +35,20 @@ func TestHandler(t *testing.T) {
 		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
 	})
 
+	t.Run("jwks", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/auth/.well-known/jwks.json", nil)
+		w := httptest.NewRecorder()
+		testHandler.ServeHTTP(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		var jwks JWKS
+		err := json.NewDecoder(res.Body).Decode(&jwks)
+		assert.Nil(t, err)
+		// hmac key is never published
+		assert.Empty(t, jwks.Keys)
+	})
+
 	t.Run("action not supported", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/auth/x", nil)
 		w := httptest.NewRecorder()
@@ -112,6 +131,38 @@ func TestHandlerActions(t *testing.T) {
 		assert.Equal(t, http.StatusNotFound, res.StatusCode)
 	})
 
+	t.Run("refresh", func(t *testing.T) {
+		tokens := login(t, "hello", "world")
+		assert.NotEmpty(t, tokens["refresh_token"])
+
+		refresh := func(refreshToken string) (int, map[string]string) {
+			body := strings.NewReader(`{"refresh_token": "` + refreshToken + `"}`)
+			req := httptest.NewRequest(http.MethodPost, "/auth/refresh", body)
+			w := httptest.NewRecorder()
+			testHandler.ServeHTTP(w, req)
+			res := w.Result()
+			defer res.Body.Close()
+			var resData map[string]string
+			_ = json.NewDecoder(res.Body).Decode(&resData)
+			return res.StatusCode, resData
+		}
+
+		code, newTokens := refresh(tokens["refresh_token"])
+		assert.Equal(t, http.StatusOK, code)
+		assert.NotEmpty(t, newTokens["token"])
+		assert.NotEqual(t, tokens["refresh_token"], newTokens["refresh_token"])
+
+		t.Log("reuse a rotated refresh token revokes the family")
+		code, _ = refresh(tokens["refresh_token"])
+		assert.Equal(t, http.StatusUnauthorized, code)
+		code, _ = refresh(newTokens["refresh_token"])
+		assert.Equal(t, http.StatusUnauthorized, code)
+
+		t.Log("invalid refresh token")
+		code, _ = refresh("invalid")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
 	t.Run("logout", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
 		w := httptest.NewRecorder()
@@ -121,3 +172,206 @@ func TestHandlerActions(t *testing.T) {
 		assert.Equal(t, http.StatusOK, res.StatusCode)
 	})
 }
+
+func login(t *testing.T, username, password string) map[string]string {
+	body := strings.NewReader(`{"username": "` + username + `", "password": "` + password + `"}`)
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", body)
+	w := httptest.NewRecorder()
+	testHandler.ServeHTTP(w, req)
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var resData map[string]string
+	err := json.NewDecoder(res.Body).Decode(&resData)
+	assert.Nil(t, err)
+	return resData
+}
+
+func TestNew(t *testing.T) {
+	t.Run("signing key is required", func(t *testing.T) {
+		_, err := New(DB(testHandler.db))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("database is required", func(t *testing.T) {
+		_, err := New(SigningKey(testKey))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("notifications are required to verify emails", func(t *testing.T) {
+		_, err := New(DB(testHandler.db), SigningKey(testKey), RequireVerifiedEmail())
+		assert.NotNil(t, err)
+	})
+
+	t.Run("options", func(t *testing.T) {
+		var registered, loggedIn *User
+		handler, err := New(
+			DB(testHandler.db),
+			SigningKey(testKey),
+			Prefix("/api/auth"),
+			PasswordValidator(func(username, password string) error {
+				if password == username {
+					return errors.New("password can't be the same as username")
+				}
+				return nil
+			}),
+			AfterRegister(func(ctx context.Context, user *User) { registered = user }),
+			AfterLogin(func(ctx context.Context, user *User) { loggedIn = user }),
+		)
+		assert.Nil(t, err)
+		defer handler.Close()
+
+		post := func(path, body string) int {
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			res := w.Result()
+			defer res.Body.Close()
+			return res.StatusCode
+		}
+		assert.Equal(t, http.StatusBadRequest, post("/api/auth/register", `{"username": "options", "password": "options"}`))
+		assert.Equal(t, http.StatusOK, post("/api/auth/register", `{"username": "options", "password": "world"}`))
+		assert.Equal(t, "options", registered.Username)
+		assert.NotZero(t, registered.ID)
+		assert.Equal(t, http.StatusOK, post("/api/auth/login", `{"username": "options", "password": "world"}`))
+		assert.Equal(t, registered.ID, loggedIn.ID)
+		// the created policy store doesn't poll
+		assert.Less(t, handler.PolicyStore().interval, time.Duration(0))
+	})
+}
+
+func TestHandlerClose(t *testing.T) {
+	t.Run("shared database is not closed", func(t *testing.T) {
+		handler, err := NewHandlerWithDB(testHandler.db, testKey)
+		assert.Nil(t, err)
+		assert.Nil(t, handler.Close())
+		assert.Nil(t, testHandler.db.Ping())
+
+		handler, err = New(StdDB(testHandler.db.DB, testHandler.db.DriverName), SigningKey(testKey))
+		assert.Nil(t, err)
+		assert.Nil(t, handler.Close())
+		assert.Nil(t, testHandler.db.Ping())
+	})
+
+	t.Run("opened database is closed", func(t *testing.T) {
+		handler, err := NewHandler("sqlite://ci.db", testKey)
+		assert.Nil(t, err)
+		assert.Nil(t, handler.Close())
+		assert.NotNil(t, handler.db.Ping())
+	})
+}
+
+func TestHandlerSetup(t *testing.T) {
+	file, err := os.CreateTemp(".", "test-")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+
+	t.Run("disabled", func(t *testing.T) {
+		handler, err := NewHandler("sqlite://"+file.Name(), testKey, DisableSetup())
+		assert.Nil(t, err)
+		defer handler.Close()
+		req := httptest.NewRequest(http.MethodPost, "/auth/setup", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+
+	t.Setenv(SetupTokenEnv, "bootstrap")
+	handler, err := NewHandler("sqlite://"+file.Name(), testKey, SetupAdmin("admin", "generated-by-deploy"))
+	assert.Nil(t, err)
+	defer handler.Close()
+
+	t.Run("invalid token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/auth/setup", nil)
+		req.Header.Set("X-Setup-Token", "guess")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("setup", func(t *testing.T) {
+		body := strings.NewReader(`{"username": "root"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/setup", body)
+		req.Header.Set("X-Setup-Token", "bootstrap")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		data, err := io.ReadAll(res.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, `{"Username":"root"}`, strings.TrimSpace(string(data)))
+
+		user, err := handler.authenticate("root", "", "generated-by-deploy")
+		assert.Nil(t, err)
+		assert.True(t, user.IsAdmin)
+	})
+
+	t.Run("setup is done", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/auth/setup", nil)
+		req.Header.Set("X-Setup-Token", "bootstrap")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+}
+
+func TestHandlerPassword(t *testing.T) {
+	status, _ := serve(t, testHandler, http.MethodPost, "/auth/register", "", `{"username": "password_user", "password": "world"}`)
+	assert.Equal(t, http.StatusOK, status)
+	token := login(t, "password_user", "world")["token"]
+
+	t.Run("change", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodPost, "/auth/password/change", "", `{"old_password": "world", "new_password": "hello"}`)
+		assert.Equal(t, http.StatusUnauthorized, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/password/change", token, `{"old_password": "wrong", "new_password": "hello"}`)
+		assert.Equal(t, http.StatusForbidden, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/password/change", token, `{"old_password": "world", "new_password": ""}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, data := serve(t, testHandler, http.MethodPost, "/auth/password/change", token, `{"old_password": "world", "new_password": "hello"}`)
+		assert.Equal(t, http.StatusOK, status)
+		var res tokenResponse
+		assert.Nil(t, json.Unmarshal(data, &res))
+		assert.NotEmpty(t, res.Token)
+
+		// the old token is revoked
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/password/change", token, `{"old_password": "hello", "new_password": "world"}`)
+		assert.Equal(t, http.StatusUnauthorized, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/login", "", `{"username": "password_user", "password": "world"}`)
+		assert.Equal(t, http.StatusUnauthorized, status)
+		token = login(t, "password_user", "hello")["token"]
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		data, err := ParseJWTToken(testKey, token)
+		assert.Nil(t, err)
+		path := "/auth/users/" + strconv.FormatInt(toInt64(data["user_id"]), 10) + "/password"
+		admin := adminToken(t)
+
+		status, _ := serve(t, testHandler, http.MethodPost, path, token, `{"password": "reset"}`)
+		assert.Equal(t, http.StatusForbidden, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/users/100000/password", admin, `{"password": "reset"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/users/abc/password", admin, `{"password": "reset"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = serve(t, testHandler, http.MethodGet, path, admin, "")
+		assert.Equal(t, http.StatusMethodNotAllowed, status)
+		status, body := serve(t, testHandler, http.MethodPost, path, admin, `{"password": ""}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Contains(t, string(body), `"errors":[`)
+
+		status, _ = serve(t, testHandler, http.MethodPost, path, admin, `{"password": "reset"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/logout_all", token, "")
+		assert.Equal(t, http.StatusUnauthorized, status)
+		login(t, "password_user", "reset")
+	})
+}
@@ -0,0 +1,295 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrHashFormat is returned by PasswordHasher.Verify if the hash is not of
+// the format of the hasher
+var ErrHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords into encoded hashes which record the
+// algorithm and the parameters, e.g. `$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>`
+type PasswordHasher interface {
+	// Hash returns the encoded hash of password
+	Hash(password string) (string, error)
+	// Verify returns whether password matches the encoded hash, ErrHashFormat
+	// is returned if the hash is not of the format of the hasher
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash returns whether the encoded hash is not created by the
+	// hasher with its current parameters
+	NeedsRehash(encoded string) bool
+}
+
+// DefaultPasswordHasher is used by HashPassword and Handler without the
+// Hasher option
+var DefaultPasswordHasher PasswordHasher = &BcryptHasher{Cost: bcrypt.DefaultCost}
+
+// builtinHashers verify the hashes not of the format of the hasher in use,
+// so that passwords can be migrated to another algorithm
+var builtinHashers = []PasswordHasher{
+	&BcryptHasher{},
+	&Argon2idHasher{},
+	&ScryptHasher{},
+}
+
+// verifyPassword verifies password with hasher, or the builtin hasher of the
+// hash format, rehash is true if the password matches but the hash needs to be
+// rehashed with hasher
+func verifyPassword(hasher PasswordHasher, password, encoded string) (match, rehash bool, err error) {
+	match, err = hasher.Verify(password, encoded)
+	for i := 0; errors.Is(err, ErrHashFormat) && i < len(builtinHashers); i++ {
+		match, err = builtinHashers[i].Verify(password, encoded)
+	}
+	if err != nil || !match {
+		return false, false, err
+	}
+	return true, hasher.NeedsRehash(encoded), nil
+}
+
+func genSalt(length int) ([]byte, error) {
+	salt := make([]byte, length)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// phcB64 is the base64 encoding of the salt and hash in encoded hashes
+var phcB64 = base64.RawStdEncoding
+
+// BcryptHasher hashes passwords with bcrypt, only the first 72 bytes of a
+// password are used
+type BcryptHasher struct {
+	// Cost defaults to bcrypt.DefaultCost
+	Cost int
+}
+
+func (h *BcryptHasher) cost() int {
+	if h.Cost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return h.Cost
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.cost())
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	if !strings.HasPrefix(encoded, "$2") {
+		return false, ErrHashFormat
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost()
+}
+
+// Argon2idHasher hashes passwords with argon2id, the zero value uses the
+// parameters recommended by RFC 9106 for memory constrained environments
+type Argon2idHasher struct {
+	Time       uint32 // defaults to 3
+	Memory     uint32 // in KiB, defaults to 64 MiB
+	Threads    uint8  // defaults to 4
+	KeyLength  uint32 // defaults to 32
+	SaltLength int    // defaults to 16
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (h *Argon2idHasher) params() (t, m uint32, p uint8, keyLen uint32, saltLen int) {
+	t, m, p, keyLen, saltLen = h.Time, h.Memory, h.Threads, h.KeyLength, h.SaltLength
+	if t == 0 {
+		t = 3
+	}
+	if m == 0 {
+		m = 64 * 1024
+	}
+	if p == 0 {
+		p = 4
+	}
+	if keyLen == 0 {
+		keyLen = 32
+	}
+	if saltLen == 0 {
+		saltLen = 16
+	}
+	return
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	t, m, p, keyLen, saltLen := h.params()
+	salt, err := genSalt(saltLen)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, t, m, p, keyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, m, t, p, phcB64.EncodeToString(salt), phcB64.EncodeToString(key)), nil
+}
+
+// decode parses `$argon2id$v=19$m=<m>,t=<t>,p=<p>$<salt>$<key>`
+func (h *Argon2idHasher) decode(encoded string) (t, m uint32, p uint8, salt, key []byte, err error) {
+	if !strings.HasPrefix(encoded, argon2idPrefix) {
+		return 0, 0, 0, nil, nil, ErrHashFormat
+	}
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return 0, 0, 0, nil, nil, errors.New("invalid argon2id hash")
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return 0, 0, 0, nil, nil, fmt.Errorf("unsupported argon2id version: %s", parts[2])
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &m, &t, &p); err != nil {
+		return 0, 0, 0, nil, nil, fmt.Errorf("invalid argon2id parameters: %s", parts[3])
+	}
+	if salt, err = phcB64.DecodeString(parts[4]); err != nil {
+		return 0, 0, 0, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	if key, err = phcB64.DecodeString(parts[5]); err != nil {
+		return 0, 0, 0, nil, nil, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+	return t, m, p, salt, key, nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	t, m, p, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, t, m, p, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	t, m, p, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return true
+	}
+	wantT, wantM, wantP, keyLen, saltLen := h.params()
+	return t != wantT || m != wantM || p != wantP || uint32(len(key)) != keyLen || len(salt) != saltLen
+}
+
+// ScryptHasher hashes passwords with scrypt, the zero value uses N=32768,
+// r=8 and p=1
+type ScryptHasher struct {
+	N          int // CPU/memory cost, a power of 2 greater than 1
+	R          int
+	P          int
+	KeyLength  int // defaults to 32
+	SaltLength int // defaults to 16
+}
+
+const scryptPrefix = "$scrypt$"
+
+func (h *ScryptHasher) params() (n, r, p, keyLen, saltLen int) {
+	n, r, p, keyLen, saltLen = h.N, h.R, h.P, h.KeyLength, h.SaltLength
+	if n == 0 {
+		n = 1 << 15
+	}
+	if r == 0 {
+		r = 8
+	}
+	if p == 0 {
+		p = 1
+	}
+	if keyLen == 0 {
+		keyLen = 32
+	}
+	if saltLen == 0 {
+		saltLen = 16
+	}
+	return
+}
+
+// log2 returns the exponent of n if it's a power of 2, or -1
+func log2(n int) int {
+	for ln := 1; ln < 63; ln++ {
+		if 1<<ln == n {
+			return ln
+		}
+	}
+	return -1
+}
+
+func (h *ScryptHasher) Hash(password string) (string, error) {
+	n, r, p, keyLen, saltLen := h.params()
+	ln := log2(n)
+	if ln < 0 {
+		return "", fmt.Errorf("scrypt N must be a power of 2 greater than 1: %d", n)
+	}
+	salt, err := genSalt(saltLen)
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, n, r, p, keyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%sln=%d,r=%d,p=%d$%s$%s",
+		scryptPrefix, ln, r, p, phcB64.EncodeToString(salt), phcB64.EncodeToString(key)), nil
+}
+
+// decode parses `$scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<key>`
+func (h *ScryptHasher) decode(encoded string) (n, r, p int, salt, key []byte, err error) {
+	if !strings.HasPrefix(encoded, scryptPrefix) {
+		return 0, 0, 0, nil, nil, ErrHashFormat
+	}
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return 0, 0, 0, nil, nil, errors.New("invalid scrypt hash")
+	}
+	var ln int
+	if _, err = fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &ln, &r, &p); err != nil || ln < 1 || ln > 62 {
+		return 0, 0, 0, nil, nil, fmt.Errorf("invalid scrypt parameters: %s", parts[2])
+	}
+	if salt, err = phcB64.DecodeString(parts[3]); err != nil {
+		return 0, 0, 0, nil, nil, fmt.Errorf("invalid scrypt salt: %w", err)
+	}
+	if key, err = phcB64.DecodeString(parts[4]); err != nil {
+		return 0, 0, 0, nil, nil, fmt.Errorf("invalid scrypt hash: %w", err)
+	}
+	return 1 << ln, r, p, salt, key, nil
+}
+
+func (h *ScryptHasher) Verify(password, encoded string) (bool, error) {
+	n, r, p, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+	other, err := scrypt.Key([]byte(password), salt, n, r, p, len(key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *ScryptHasher) NeedsRehash(encoded string) bool {
+	n, r, p, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return true
+	}
+	wantN, wantR, wantP, keyLen, saltLen := h.params()
+	return n != wantN || r != wantR || p != wantP || len(key) != keyLen || len(salt) != saltLen
+}
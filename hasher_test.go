@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := map[string]PasswordHasher{
+		"$2a$04$":                        &BcryptHasher{Cost: 4},
+		"$argon2id$v=19$m=1024,t=1,p=1$": &Argon2idHasher{Time: 1, Memory: 1024, Threads: 1},
+		"$scrypt$ln=10,r=8,p=1$":         &ScryptHasher{N: 1024},
+	}
+	for prefix, hasher := range hashers {
+		encoded, err := hasher.Hash("world")
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(encoded, prefix), encoded)
+
+		match, err := hasher.Verify("world", encoded)
+		assert.Nil(t, err)
+		assert.True(t, match)
+		match, err = hasher.Verify("hello", encoded)
+		assert.Nil(t, err)
+		assert.False(t, match)
+		assert.False(t, hasher.NeedsRehash(encoded))
+
+		for otherPrefix, other := range hashers {
+			if otherPrefix == prefix {
+				continue
+			}
+			_, err := other.Verify("world", encoded)
+			assert.ErrorIs(t, err, ErrHashFormat)
+			assert.True(t, other.NeedsRehash(encoded))
+		}
+	}
+
+	t.Run("outdated parameters", func(t *testing.T) {
+		encoded, err := (&ScryptHasher{N: 1024}).Hash("world")
+		assert.Nil(t, err)
+		assert.True(t, (&ScryptHasher{N: 2048}).NeedsRehash(encoded))
+		encoded, err = (&Argon2idHasher{Time: 1, Memory: 1024, Threads: 1}).Hash("world")
+		assert.Nil(t, err)
+		assert.True(t, (&Argon2idHasher{Time: 2, Memory: 1024, Threads: 1}).NeedsRehash(encoded))
+		assert.True(t, (&BcryptHasher{Cost: 5}).NeedsRehash("$2a$04$invalid"))
+	})
+
+	t.Run("invalid hash", func(t *testing.T) {
+		_, err := (&ScryptHasher{}).Verify("world", "$scrypt$ln=abc$$")
+		assert.NotNil(t, err)
+		_, err = (&ScryptHasher{N: 1000}).Hash("world")
+		assert.NotNil(t, err)
+		match, rehash, err := verifyPassword(&BcryptHasher{}, "world", "plain")
+		assert.ErrorIs(t, err, ErrHashFormat)
+		assert.False(t, match)
+		assert.False(t, rehash)
+	})
+
+	t.Run("verify other formats", func(t *testing.T) {
+		encoded, err := (&BcryptHasher{Cost: 4}).Hash("world")
+		assert.Nil(t, err)
+		hasher := &Argon2idHasher{Time: 1, Memory: 1024, Threads: 1}
+		match, rehash, err := verifyPassword(hasher, "world", encoded)
+		assert.Nil(t, err)
+		assert.True(t, match)
+		assert.True(t, rehash)
+		match, rehash, err = verifyPassword(hasher, "hello", encoded)
+		assert.Nil(t, err)
+		assert.False(t, match)
+		assert.False(t, rehash)
+	})
+}
+
+func TestHandlerRehash(t *testing.T) {
+	ctx := context.Background()
+	hashed, err := HashPassword("world")
+	assert.Nil(t, err)
+	_, err = testHandler.db.ExecQuery(ctx, createUser, "rehash_user", hashed, nil)
+	assert.Nil(t, err)
+
+	handler, err := New(DB(testHandler.db), SigningKey(testKey), Hasher(&ScryptHasher{N: 1024}))
+	assert.Nil(t, err)
+	defer handler.Close()
+	for i := 0; i < 2; i++ {
+		user, err := handler.authenticate("rehash_user", "", "world")
+		assert.Nil(t, err)
+		assert.Equal(t, "rehash_user", user.Username)
+
+		row, err := testHandler.db.FetchOne(ctx, queryUser, "rehash_user")
+		assert.Nil(t, err)
+		assert.True(t, strings.HasPrefix(toString(row["password"]), "$scrypt$ln=10,r=8,p=1$"))
+	}
+	_, err = handler.authenticate("rehash_user", "", "hello")
+	assert.NotNil(t, err)
+	// the default handler still verifies the rehashed password
+	_, err = testHandler.authenticate("rehash_user", "", "world")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JWK represents a public JSON Web Key defined in RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS represents a JSON Web Key Set, it's the document published at
+// `/auth/.well-known/jwks.json`
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// newJWK converts a public key to JWK, symmetric keys are not supported as
+// they must never be published
+func newJWK(publicKey any) (*JWK, error) {
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			Kty: "RSA",
+			N:   b64.EncodeToString(k.N.Bytes()),
+			E:   b64.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return &JWK{
+			Kty: "EC",
+			Crv: k.Curve.Params().Name,
+			X:   b64.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   b64.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return &JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   b64.EncodeToString(k),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type for jwk: %T", publicKey)
+	}
+}
+
+// thumbprint returns the JWK thumbprint defined in RFC 7638, it's used as the
+// default key id
+func (jwk *JWK) thumbprint() string {
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jwk.E, jwk.Kty, jwk.N)
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, jwk.Crv, jwk.Kty, jwk.X, jwk.Y)
+	default:
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jwk.Crv, jwk.Kty, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return b64.EncodeToString(sum[:])
+}
+
+// Key converts the JWK to a verify-only Key
+func (jwk *JWK) Key() (*Key, error) {
+	var (
+		key *Key
+		err error
+	)
+	switch jwk.Kty {
+	case "RSA":
+		n, e, decodeErr := decodeBigInts(jwk.N, jwk.E)
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		key, err = NewPublicKey(&rsa.PublicKey{N: n, E: int(e.Int64())})
+	case "EC":
+		curves := map[string]elliptic.Curve{
+			"P-256": elliptic.P256(),
+			"P-384": elliptic.P384(),
+			"P-521": elliptic.P521(),
+		}
+		curve, ok := curves[jwk.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported ecdsa curve: %s", jwk.Crv)
+		}
+		x, y, decodeErr := decodeBigInts(jwk.X, jwk.Y)
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		key, err = NewPublicKey(&ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+	case "OKP":
+		x, decodeErr := b64.DecodeString(jwk.X)
+		if decodeErr != nil {
+			return nil, decodeErr
+		}
+		if jwk.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported okp key: %s", jwk.Crv)
+		}
+		key, err = NewPublicKey(ed25519.PublicKey(x))
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if jwk.Alg != "" && jwk.Alg != key.Method.Alg() {
+		return nil, fmt.Errorf("unsupported signing method %s for key type %s", jwk.Alg, jwk.Kty)
+	}
+	if jwk.Kid != "" {
+		key.ID = jwk.Kid
+	}
+	return key, nil
+}
+
+// ParseJWKS parses a JWKS document into a verify-only KeySet, services which
+// only verify tokens can load the document published by Handler with it
+func ParseJWKS(data []byte) (*KeySet, error) {
+	var jwks JWKS
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+	if len(jwks.Keys) == 0 {
+		return nil, errors.New("no keys found in jwks")
+	}
+
+	keys := make([]*Key, 0, len(jwks.Keys))
+	for i := range jwks.Keys {
+		key, err := jwks.Keys[i].Key()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewKeySet(nil, keys...)
+}
+
+func decodeBigInts(a, b string) (*big.Int, *big.Int, error) {
+	aData, err := b64.DecodeString(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	bData, err := b64.DecodeString(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	return new(big.Int).SetBytes(aData), new(big.Int).SetBytes(bData), nil
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Signer signs claims and returns a JWT token string
+type Signer interface {
+	Sign(claims map[string]any) (string, error)
+}
+
+// Verifier verifies the signature of a JWT token string and returns its claims,
+// the claims themselves(exp, nbf, etc.) are validated by ParseJWTToken
+type Verifier interface {
+	Verify(tokenString string) (map[string]any, error)
+}
+
+// SignerVerifier is a key which can both sign and verify tokens, it's required
+// by Handler which issues tokens
+type SignerVerifier interface {
+	Signer
+	Verifier
+}
+
+// Key is a JWT key which signs tokens with PrivateKey and verifies tokens with
+// PublicKey. For HMAC, both of them are the shared secret; for asymmetric keys,
+// a Key without PrivateKey can only verify tokens.
+//
+// ID is stamped as the `kid` header of signed tokens, it defaults to the JWK
+// thumbprint for asymmetric keys and is empty for HMAC keys.
+type Key struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey any
+	PublicKey  any
+}
+
+// NewHMACKey returns a HS256 key with the shared secret
+func NewHMACKey(secret []byte) *Key {
+	return &Key{
+		Method:     jwt.SigningMethodHS256,
+		PrivateKey: secret,
+		PublicKey:  secret,
+	}
+}
+
+// NewKey returns a key with a RSA, ECDSA or Ed25519 private key, the signing
+// method is decided by the type of the key
+func NewKey(privateKey crypto.PrivateKey) (*Key, error) {
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", privateKey)
+	}
+	key, err := NewPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.PrivateKey = privateKey
+	return key, nil
+}
+
+// NewPublicKey returns a verify-only key with a RSA, ECDSA or Ed25519 public key
+func NewPublicKey(publicKey crypto.PublicKey) (*Key, error) {
+	var method jwt.SigningMethod
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		var err error
+		method, err = ecdsaMethod(k.Curve)
+		if err != nil {
+			return nil, err
+		}
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+
+	jwk, err := newJWK(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{ID: jwk.thumbprint(), Method: method, PublicKey: publicKey}, nil
+}
+
+// ParseKeyPEM parses a PEM encoded private or public key, a private key
+// returns a key which can sign and verify, a public key returns a verify-only key
+func ParseKeyPEM(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid pem data")
+	}
+
+	var (
+		key any
+		err error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewPublicKey(key)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewPublicKey(key)
+	default:
+		return nil, fmt.Errorf("unsupported pem block type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(key)
+}
+
+// Sign implements Signer interface
+func (k *Key) Sign(claims map[string]any) (string, error) {
+	if k.PrivateKey == nil {
+		return "", errors.New("key can't sign tokens without a private key")
+	}
+	token := jwt.NewWithClaims(k.Method, jwt.MapClaims(claims))
+	if k.ID != "" {
+		token.Header["kid"] = k.ID
+	}
+	return token.SignedString(k.PrivateKey)
+}
+
+// Verify implements Verifier interface
+func (k *Key) Verify(tokenString string) (map[string]any, error) {
+	parser := jwt.NewParser(
+		// only accept the method of the key to avoid algorithm confusion
+		jwt.WithValidMethods([]string{k.Method.Alg()}),
+		jwt.WithoutClaimsValidation(),
+	)
+	token, err := parser.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		return k.PublicKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		return map[string]any(claims), nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+// JWK returns the public JWK of the key, HMAC keys can't be published
+func (k *Key) JWK() (*JWK, error) {
+	jwk, err := newJWK(k.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	jwk.Use = "sig"
+	jwk.Alg = k.Method.Alg()
+	jwk.Kid = k.ID
+	return jwk, nil
+}
+
+// JWKS returns a key set document with the public key, it's empty for HMAC keys
+func (k *Key) JWKS() *JWKS {
+	jwks := &JWKS{Keys: []JWK{}}
+	if jwk, err := k.JWK(); err == nil {
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+	return jwks
+}
+
+func ecdsaMethod(curve elliptic.Curve) (jwt.SigningMethod, error) {
+	switch curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521():
+		return jwt.SigningMethodES512, nil
+	default:
+		return nil, fmt.Errorf("unsupported ecdsa curve: %s", curve.Params().Name)
+	}
+}
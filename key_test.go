@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func genPrivateKeys(t *testing.T) map[string]crypto.PrivateKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	return map[string]crypto.PrivateKey{
+		"RS256": rsaKey,
+		"ES256": ecKey,
+		"EdDSA": edKey,
+	}
+}
+
+func TestKey(t *testing.T) {
+	data := map[string]any{"a": "b"}
+	for alg, privateKey := range genPrivateKeys(t) {
+		privateKey := privateKey
+		t.Run(alg, func(t *testing.T) {
+			key, err := NewKey(privateKey)
+			assert.Nil(t, err)
+			assert.Equal(t, alg, key.Method.Alg())
+
+			token, err := GenJWTToken(key, data)
+			assert.Nil(t, err)
+			parsedData, err := ParseJWTToken(key, token)
+			assert.Nil(t, err)
+			assert.Equal(t, data, parsedData)
+
+			// verify with public key only
+			signer := privateKey.(crypto.Signer)
+			publicKey, err := NewPublicKey(signer.Public())
+			assert.Nil(t, err)
+			parsedData, err = ParseJWTToken(publicKey, token)
+			assert.Nil(t, err)
+			assert.Equal(t, data, parsedData)
+
+			// public key can't sign tokens
+			_, err = GenJWTToken(publicKey, data)
+			assert.NotNil(t, err)
+
+			// tokens signed with other keys are rejected
+			_, err = ParseJWTToken(testKey, token)
+			assert.NotNil(t, err)
+		})
+	}
+
+	t.Run("unsupported key", func(t *testing.T) {
+		_, err := NewKey("not a key")
+		assert.NotNil(t, err)
+		_, err = NewPublicKey("not a key")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestParseKeyPEM(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.Nil(t, err)
+	privateDER, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	assert.Nil(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	assert.Nil(t, err)
+
+	privateKey, err := ParseKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}))
+	assert.Nil(t, err)
+	assert.Equal(t, "ES384", privateKey.Method.Alg())
+	publicKey, err := ParseKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	assert.Nil(t, err)
+	assert.Nil(t, publicKey.PrivateKey)
+
+	token, err := GenJWTToken(privateKey, map[string]any{"a": "b"})
+	assert.Nil(t, err)
+	_, err = ParseJWTToken(publicKey, token)
+	assert.Nil(t, err)
+
+	_, err = ParseKeyPEM([]byte("invalid"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// KeySet holds a signing key and any number of verification keys selected by
+// the `kid` header of tokens. Keys are rotated by making a new key the signing
+// key while the previous one is kept to verify tokens issued before, and is
+// removed once those tokens are expired.
+type KeySet struct {
+	mu         sync.RWMutex
+	signingKID string
+	keys       map[string]*Key
+}
+
+// NewKeySet returns a KeySet which signs with signingKey and verifies with all
+// the keys, signingKey can be nil for services which only verify tokens
+func NewKeySet(signingKey *Key, verifyKeys ...*Key) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*Key, len(verifyKeys)+1)}
+	for _, key := range verifyKeys {
+		if err := ks.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	if signingKey != nil {
+		if err := ks.Rotate(signingKey); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
+
+// Add adds a key to verify tokens, e.g. a retiring key or an upcoming key
+func (ks *KeySet) Add(key *Key) error {
+	if key.ID == "" {
+		return errors.New("key id is required in a key set")
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[key.ID] = key
+	return nil
+}
+
+// Remove removes a retired key, the signing key can't be removed
+func (ks *KeySet) Remove(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if kid == ks.signingKID {
+		return errors.New("can't remove the signing key")
+	}
+	delete(ks.keys, kid)
+	return nil
+}
+
+// Rotate makes key the signing key, the previous signing key is kept to verify
+// tokens until it's removed
+func (ks *KeySet) Rotate(key *Key) error {
+	if key.PrivateKey == nil {
+		return errors.New("signing key requires a private key")
+	}
+	if err := ks.Add(key); err != nil {
+		return err
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.signingKID = key.ID
+	return nil
+}
+
+// Sign implements Signer interface, tokens are signed by the signing key and
+// stamped with its `kid`
+func (ks *KeySet) Sign(claims map[string]any) (string, error) {
+	ks.mu.RLock()
+	key, ok := ks.keys[ks.signingKID]
+	ks.mu.RUnlock()
+	if !ok {
+		return "", errors.New("no signing key in key set")
+	}
+	return key.Sign(claims)
+}
+
+// Verify implements Verifier interface, the key is selected by the `kid`
+// header of the token
+func (ks *KeySet) Verify(tokenString string) (map[string]any, error) {
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, errors.New("no kid found in token header")
+	}
+
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown kid: %s", kid)
+	}
+	return key.Verify(tokenString)
+}
+
+// JWKS returns the public keys in the key set, HMAC keys are never published
+func (ks *KeySet) JWKS() *JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	jwks := &JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		if jwk, err := key.JWK(); err == nil {
+			jwks.Keys = append(jwks.Keys, *jwk)
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+	return jwks
+}
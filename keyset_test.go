@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeySet(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	oldKey, err := NewKey(ecKey)
+	assert.Nil(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	newKey, err := NewKey(edKey)
+	assert.Nil(t, err)
+
+	ks, err := NewKeySet(oldKey)
+	assert.Nil(t, err)
+	data := map[string]any{"a": "b"}
+	oldToken, err := GenJWTToken(ks, data)
+	assert.Nil(t, err)
+	token, _, err := jwt.NewParser().ParseUnverified(oldToken, jwt.MapClaims{})
+	assert.Nil(t, err)
+	assert.Equal(t, oldKey.ID, token.Header["kid"])
+
+	t.Run("rotate", func(t *testing.T) {
+		err := ks.Rotate(newKey)
+		assert.Nil(t, err)
+		newToken, err := GenJWTToken(ks, data)
+		assert.Nil(t, err)
+
+		// both tokens are valid during rotation
+		_, err = ParseJWTToken(ks, oldToken)
+		assert.Nil(t, err)
+		_, err = ParseJWTToken(ks, newToken)
+		assert.Nil(t, err)
+
+		// the signing key can't be removed
+		assert.NotNil(t, ks.Remove(newKey.ID))
+		// retire the old key
+		assert.Nil(t, ks.Remove(oldKey.ID))
+		_, err = ParseJWTToken(ks, oldToken)
+		assert.NotNil(t, err)
+		_, err = ParseJWTToken(ks, newToken)
+		assert.Nil(t, err)
+	})
+
+	t.Run("token without kid", func(t *testing.T) {
+		token, err := GenJWTToken(testKey, data)
+		assert.Nil(t, err)
+		_, err = ParseJWTToken(ks, token)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("key without id", func(t *testing.T) {
+		_, err := NewKeySet(testKey)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	for alg, privateKey := range genPrivateKeys(t) {
+		privateKey := privateKey
+		t.Run(alg, func(t *testing.T) {
+			key, err := NewKey(privateKey)
+			assert.Nil(t, err)
+			ks, err := NewKeySet(key)
+			assert.Nil(t, err)
+
+			// publish the key set and load it in another service
+			data, err := json.Marshal(ks.JWKS())
+			assert.Nil(t, err)
+			publicKS, err := ParseJWKS(data)
+			assert.Nil(t, err)
+
+			token, err := GenJWTToken(ks, map[string]any{"a": "b"})
+			assert.Nil(t, err)
+			_, err = ParseJWTToken(publicKS, token)
+			assert.Nil(t, err)
+			// verify-only key set can't sign tokens
+			_, err = GenJWTToken(publicKS, map[string]any{"a": "b"})
+			assert.NotNil(t, err)
+		})
+	}
+
+	t.Run("hmac keys are not published", func(t *testing.T) {
+		assert.Empty(t, testKey.JWKS().Keys)
+	})
+}
@@ -2,12 +2,9 @@ package auth
 
 import (
 	"context"
-
-	"net/http"
 	"os"
 	"testing"
 
-	j "github.com/rest-go/rest/pkg/jsonutil"
 	"github.com/rest-go/rest/pkg/log"
 )
 
@@ -15,30 +12,44 @@ var testHandler *Handler
 
 const testSecret = "test-secret"
 
+var testKey = NewHMACKey([]byte(testSecret))
+
 func TestMain(m *testing.M) {
 	var err error
-	testHandler, err = NewHandler("sqlite://ci.db", []byte(testSecret))
+	testHandler, err = NewHandler("sqlite://ci.db", testKey)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// drop previous test tables
-	_, err = testHandler.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS auth_users")
-	if err != nil {
-		log.Fatal(err)
-	}
-	_, err = testHandler.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS auth_policies")
-	if err != nil {
+	// drop previous test tables and setup auth tables
+	if err := resetTables(testHandler); err != nil {
 		log.Fatal(err)
 	}
 
-	// setup auth tables
-	val := testHandler.setup()
-	if res, ok := val.(*j.Response); ok {
-		if res.Code != http.StatusOK {
-			log.Fatal(res.Msg)
+	os.Exit(m.Run())
+}
+
+// resetTables drops all the auth tables and runs setup again
+func resetTables(h *Handler) error {
+	tables := []string{
+		UserTableName,
+		PolicyTableName,
+		RefreshTokenTableName,
+		RevokedTokenTableName,
+		RoleTableName,
+		UserRoleTableName,
+		GroupTableName,
+		GroupMemberTableName,
+		GroupRoleTableName,
+		OneTimeTokenTableName,
+		MigrationTableName,
+	}
+	for _, table := range tables {
+		_, err := h.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS "+table)
+		if err != nil {
+			return err
 		}
 	}
-
-	os.Exit(m.Run())
+	_, _, err := Setup(h.db)
+	return err
 }
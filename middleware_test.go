@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	j "github.com/rest-go/rest/pkg/jsonutil"
 	"github.com/stretchr/testify/assert"
@@ -27,9 +28,8 @@ func testHandle(w http.ResponseWriter, r *http.Request) {
 }
 
 func TestHandlerMiddleware(t *testing.T) {
-	_, err := testHandler.db.ExecQuery(context.Background(), "DROP TABLE IF EXISTS auth_users")
+	err := resetTables(testHandler)
 	assert.Nil(t, err)
-	_ = testHandler.setup()
 
 	body := strings.NewReader(`{
 			"username": "hello",
@@ -67,7 +67,7 @@ func TestHandlerMiddleware(t *testing.T) {
 		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
 	})
 	t.Run("authorized", func(t *testing.T) {
-		middleware := NewMiddleware([]byte(testSecret))
+		middleware := NewMiddleware(testKey)
 		authHandler := middleware(http.HandlerFunc(testHandle))
 
 		w := httptest.NewRecorder()
@@ -88,3 +88,123 @@ func TestHandlerMiddleware(t *testing.T) {
 		assert.Nil(t, err)
 	})
 }
+
+func TestRevocationMiddleware(t *testing.T) {
+	body := strings.NewReader(`{"username": "revoke", "password": "world"}`)
+	req := httptest.NewRequest(http.MethodPost, "/auth/register", body)
+	w := httptest.NewRecorder()
+	testHandler.ServeHTTP(w, req)
+
+	middleware := NewMiddleware(testKey, Revocations(testHandler.RevocationStore()))
+	authHandler := middleware(http.HandlerFunc(testHandle))
+	get := func(token string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Add(AuthorizationHeader, "Bearer "+token)
+		authHandler.ServeHTTP(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		return res.StatusCode
+	}
+	post := func(action, token, body string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/auth/"+action, strings.NewReader(body))
+		req.Header.Add(AuthorizationHeader, "Bearer "+token)
+		testHandler.ServeHTTP(w, req)
+		res := w.Result()
+		defer res.Body.Close()
+		return res.StatusCode
+	}
+
+	t.Run("logout", func(t *testing.T) {
+		tokens := login(t, "revoke", "world")
+		assert.Equal(t, http.StatusOK, get(tokens["token"]))
+		assert.Equal(t, http.StatusOK, post("logout", tokens["token"], `{"refresh_token": "`+tokens["refresh_token"]+`"}`))
+		assert.Equal(t, http.StatusUnauthorized, get(tokens["token"]))
+		assert.Equal(t, http.StatusUnauthorized, post("refresh", "", `{"refresh_token": "`+tokens["refresh_token"]+`"}`))
+		// a revoked token can't log out again
+		assert.Equal(t, http.StatusUnauthorized, post("logout", tokens["token"], ""))
+	})
+
+	t.Run("revoke twice", func(t *testing.T) {
+		store := NewRevocationStore(testHandler.db, 0)
+		ctx := context.Background()
+		expiresAt := time.Now().Add(time.Hour)
+		assert.Nil(t, store.Revoke(ctx, "revoke-twice", expiresAt))
+		assert.Nil(t, store.Revoke(ctx, "revoke-twice", expiresAt))
+		revoked, err := store.IsRevoked(ctx, "revoke-twice")
+		assert.Nil(t, err)
+		assert.True(t, revoked)
+	})
+
+	t.Run("logout all sessions", func(t *testing.T) {
+		tokens1 := login(t, "revoke", "world")
+		tokens2 := login(t, "revoke", "world")
+		assert.Equal(t, http.StatusOK, get(tokens1["token"]))
+		assert.Equal(t, http.StatusOK, get(tokens2["token"]))
+
+		assert.Equal(t, http.StatusOK, post("logout_all", tokens1["token"], ""))
+		assert.Equal(t, http.StatusUnauthorized, get(tokens1["token"]))
+		assert.Equal(t, http.StatusUnauthorized, get(tokens2["token"]))
+		assert.Equal(t, http.StatusUnauthorized, post("refresh", "", `{"refresh_token": "`+tokens2["refresh_token"]+`"}`))
+
+		// new login works after logging out all sessions
+		tokens3 := login(t, "revoke", "world")
+		assert.Equal(t, http.StatusOK, get(tokens3["token"]))
+	})
+
+	t.Run("logout all requires a valid token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, post("logout_all", "invalid", ""))
+	})
+}
+
+func TestClaimsMiddleware(t *testing.T) {
+	handler, err := NewHandler("sqlite://ci.db", testKey, ClaimsBuilder(func(ctx context.Context, user *User) (map[string]any, error) {
+		return map[string]any{
+			"tenant_id": 42,
+			"name":      "Hello " + user.Username,
+			"user_id":   -1, // reserved claims can't be overridden
+			"roles":     []string{"superuser"},
+			"groups":    []string{"admins"},
+
+			"email_verified": true,
+		}, nil
+	}))
+	assert.Nil(t, err)
+	defer handler.Close()
+	body := strings.NewReader(`{"username": "claims", "password": "world"}`)
+	req := httptest.NewRequest(http.MethodPost, "/auth/register", body)
+	testHandler.ServeHTTP(httptest.NewRecorder(), req)
+
+	body = strings.NewReader(`{"username": "claims", "password": "world"}`)
+	req = httptest.NewRequest(http.MethodPost, "/auth/login", body)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	res := w.Result()
+	defer res.Body.Close()
+	var tokens map[string]string
+	err = json.NewDecoder(res.Body).Decode(&tokens)
+	assert.Nil(t, err)
+
+	var parsedTenantID int64
+	middleware := NewMiddleware(testKey, ClaimsParser(func(claims map[string]any, user *User) error {
+		parsedTenantID = int64(claims["tenant_id"].(float64))
+		return nil
+	}))
+	var user *User
+	authHandler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = GetUser(r)
+	}))
+	req = httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Add(AuthorizationHeader, "Bearer "+tokens["token"])
+	authHandler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.True(t, user.IsAuthenticated())
+	assert.Equal(t, "Hello claims", user.Claim("name"))
+	assert.Equal(t, float64(42), user.Claim("tenant_id"))
+	assert.Nil(t, user.Claim("user_id"))
+	assert.Empty(t, user.Roles)
+	assert.Empty(t, user.Groups)
+	assert.False(t, user.EmailVerified)
+	assert.Equal(t, int64(42), parsedTenantID)
+}
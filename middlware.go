@@ -2,10 +2,10 @@ package auth
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
-
-	"github.com/rest-go/rest/pkg/log"
 )
 
 type AuthUserCtxKey string
@@ -18,21 +18,23 @@ const (
 // Middleware is a type alias for http handler middleware
 type Middleware func(http.Handler) http.Handler
 
-// NewMiddleware create a middleware using provided secret
-func NewMiddleware(secret []byte) Middleware {
+// NewMiddleware create a middleware using provided verifier, services which
+// only verify tokens can use a Key created by NewPublicKey or ParseKeyPEM.
+// Revoked tokens are checked when the Revocations option is provided.
+func NewMiddleware(verifier Verifier, opts ...Option) Middleware {
+	o := newOptions(opts)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user := &User{}
-			tokenString := strings.TrimPrefix(r.Header.Get(AuthorizationHeader), "Bearer ")
+			tokenString := bearerToken(r)
 			if tokenString != "" {
-				data, err := ParseJWTToken(secret, tokenString)
+				data, err := parseToken(r.Context(), verifier, o, tokenString)
 				if err == nil {
-					user = &User{ID: int64(data["user_id"].(float64))}
-					if isAdmin, ok := data["is_admin"]; ok {
-						user.IsAdmin = isAdmin.(bool)
-					}
-				} else {
-					log.Warn("parse jwt token with error: ", err)
+					user, err = newUserFromClaims(data, o.claimsParser)
+				}
+				if err != nil {
+					user = &User{}
+					o.logger.Warnf("parse jwt token with error: %v", err)
 				}
 			}
 
@@ -45,6 +47,59 @@ func NewMiddleware(secret []byte) Middleware {
 	}
 }
 
+// bearerToken returns the token in the Authorization header
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get(AuthorizationHeader), "Bearer ")
+}
+
+// parseToken parses the token with token options and checks whether it's
+// revoked when a revocation store is provided
+func parseToken(ctx context.Context, verifier Verifier, o *options, tokenString string) (map[string]any, error) {
+	data, err := ParseJWTTokenWithOptions(verifier, tokenString, &o.token)
+	if err != nil {
+		return nil, err
+	}
+	if o.revocations != nil {
+		revoked, err := isTokenRevoked(ctx, o.revocations, data)
+		if err != nil {
+			return nil, fmt.Errorf("check revoked token error: %w", err)
+		}
+		if revoked {
+			return nil, errors.New("token is revoked")
+		}
+	}
+	return data, nil
+}
+
+// newUserFromClaims creates user from claims, custom claims are copied to
+// User.Claims and then parsed by parser if provided
+func newUserFromClaims(data map[string]any, parser ClaimsParserFunc) (*User, error) {
+	user := &User{ID: toInt64(data["user_id"])}
+	if isAdmin, ok := data["is_admin"].(bool); ok {
+		user.IsAdmin = isAdmin
+	}
+	if verified, ok := data["email_verified"].(bool); ok {
+		user.EmailVerified = verified
+	}
+	user.Roles = parseNames(data["roles"])
+	user.Groups = parseNames(data["groups"])
+	for k, v := range data {
+		if _, ok := reservedClaims[k]; ok {
+			continue
+		}
+		if user.Claims == nil {
+			user.Claims = map[string]any{}
+		}
+		user.Claims[k] = v
+	}
+	if parser != nil {
+		if err := parser(data, user); err != nil {
+			return nil, fmt.Errorf("parse claims error: %w", err)
+		}
+	}
+	return user, nil
+}
+
 // GetUser return the user in request context
 func GetUser(r *http.Request) *User {
 	v := r.Context().Value(AuthUserKey)
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// The name of the schema migrations table
+	MigrationTableName = "auth_schema_migrations"
+
+	createMigrationTable = `
+	CREATE TABLE IF NOT EXISTS auth_schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name VARCHAR(256) NOT NULL,
+		applied_at BIGINT NOT NULL
+	)
+	`
+	createMigration = `INSERT INTO auth_schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`
+	queryMigrations = `SELECT version, applied_at FROM auth_schema_migrations`
+)
+
+// migrationFS holds the migration files named as `<version>_<name>.sql`, each
+// file is a template rendered with the dialect of the database
+//
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// dialect holds the SQL differences between databases for migrations and
+// row filters
+type dialect struct {
+	Driver     string
+	PrimaryKey string
+	Quote      string // identifier quote
+}
+
+var dialects = map[string]dialect{
+	"postgres": {
+		Driver:     "postgres",
+		PrimaryKey: "BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY",
+		Quote:      `"`,
+	},
+	"mysql": {
+		Driver:     "mysql",
+		PrimaryKey: "BIGINT PRIMARY KEY AUTO_INCREMENT",
+		Quote:      "`",
+	},
+	"sqlite": {
+		Driver:     "sqlite",
+		PrimaryKey: "INTEGER PRIMARY KEY",
+		Quote:      `"`,
+	},
+}
+
+// Migration represents a versioned schema migration and its status
+type Migration struct {
+	Version   int64     `json:"version"`
+	Name      string    `json:"name"`
+	Applied   bool      `json:"applied"`
+	AppliedAt time.Time `json:"applied_at,omitempty"`
+
+	file string
+}
+
+// Migrate applies all the pending migrations in version order, it's safe to
+// call it on every start up. The Logging option sets the logger of the
+// migrations.
+func Migrate(ctx context.Context, db *sql.DB, opts ...Option) error {
+	logger := newOptions(opts).logger
+	d, ok := dialects[db.DriverName]
+	if !ok {
+		return fmt.Errorf("unsupported database driver: %s", db.DriverName)
+	}
+	if _, dbErr := db.ExecQuery(ctx, createMigrationTable); dbErr != nil {
+		return dbErr
+	}
+
+	migrations, err := MigrationStatus(ctx, db, opts...)
+	if err != nil {
+		return err
+	}
+	for i := range migrations {
+		m := &migrations[i]
+		if m.Applied {
+			continue
+		}
+		logger.Infof("apply migration %d_%s", m.Version, m.Name)
+		if err := applyMigration(ctx, db, &d, m); err != nil {
+			return fmt.Errorf("apply migration %d_%s error: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrationStatus returns all the migrations in version order with whether
+// they are applied to db, the Logging option sets the logger like Migrate
+func MigrationStatus(ctx context.Context, db *sql.DB, opts ...Option) ([]Migration, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := map[int64]int64{}
+	rows, dbErr := db.FetchData(ctx, queryMigrations)
+	if dbErr == nil {
+		for _, row := range rows {
+			applied[toInt64(row["version"])] = toInt64(row["applied_at"])
+		}
+	} else {
+		// the migrations table doesn't exist, nothing is applied
+		newOptions(opts).logger.Warnf("fetch applied migrations error: %v", dbErr)
+	}
+	for i := range migrations {
+		if appliedAt, ok := applied[migrations[i].Version]; ok {
+			migrations[i].Applied = true
+			migrations[i].AppliedAt = time.Unix(appliedAt, 0)
+		}
+	}
+	return migrations, nil
+}
+
+func loadMigrations() ([]Migration, error) {
+	entries, err := migrationFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		parts := strings.SplitN(name, "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version: %s", entry.Name())
+		}
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    parts[1],
+			file:    path.Join("migrations", entry.Name()),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// applyMigration executes the statements of the migration and records it in
+// a transaction, note that MySQL commits DDL statements implicitly
+func applyMigration(ctx context.Context, db *sql.DB, d *dialect, m *Migration) error {
+	content, err := migrationFS.ReadFile(m.file)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(m.file).Parse(string(content))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, d); err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	for _, stmt := range strings.Split(buf.String(), ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	query := sql.Rebind(db.DriverName, createMigration)
+	if _, err := tx.ExecContext(ctx, query, m.Version, m.Name, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
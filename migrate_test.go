@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/rest-go/rest/pkg/log"
+	"github.com/rest-go/rest/pkg/sql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrate(t *testing.T) {
+	file, err := os.CreateTemp(".", "test-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	db, err := sql.Open("sqlite://" + file.Name())
+	assert.Nil(t, err)
+	defer db.Close()
+	ctx := context.Background()
+
+	t.Run("pending", func(t *testing.T) {
+		migrations, err := MigrationStatus(ctx, db)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, migrations)
+		for _, m := range migrations {
+			assert.False(t, m.Applied)
+		}
+	})
+
+	t.Run("migrate", func(t *testing.T) {
+		err := Migrate(ctx, db)
+		assert.Nil(t, err)
+
+		// migrate again is a no-op
+		err = Migrate(ctx, db)
+		assert.Nil(t, err)
+
+		migrations, err := MigrationStatus(ctx, db)
+		assert.Nil(t, err)
+		for i, m := range migrations {
+			assert.True(t, m.Applied)
+			assert.False(t, m.AppliedAt.IsZero())
+			if i > 0 {
+				assert.Greater(t, m.Version, migrations[i-1].Version)
+			}
+		}
+	})
+
+	t.Run("setup after migrate", func(t *testing.T) {
+		_, _, err := Setup(db)
+		assert.Nil(t, err)
+	})
+
+	t.Run("unsupported driver", func(t *testing.T) {
+		err := Migrate(ctx, &sql.DB{DB: db.DB, DriverName: "oracle"})
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS auth_users (
+	id {{.PrimaryKey}},
+	username VARCHAR(32) UNIQUE NOT NULL,
+	password VARCHAR(72) NOT NULL,
+	is_admin bool NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS auth_policies (
+	id {{.PrimaryKey}},
+	description VARCHAR(256) NOT NULL,
+	table_name VARCHAR(128) NOT NULL,
+	action VARCHAR(16) NOT NULL,
+	expression VARCHAR(128) NOT NULL
+);
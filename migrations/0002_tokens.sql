@@ -0,0 +1,19 @@
+ALTER TABLE auth_users ADD COLUMN token_version BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS auth_refresh_tokens (
+	id {{.PrimaryKey}},
+	user_id BIGINT NOT NULL,
+	family VARCHAR(64) NOT NULL,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	expires_at BIGINT NOT NULL,
+	used bool NOT NULL DEFAULT false,
+	revoked bool NOT NULL DEFAULT false
+);
+
+CREATE INDEX auth_refresh_tokens_family ON auth_refresh_tokens (family);
+CREATE INDEX auth_refresh_tokens_user_id ON auth_refresh_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS auth_revoked_tokens (
+	jti VARCHAR(64) PRIMARY KEY,
+	expires_at BIGINT NOT NULL
+);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS auth_roles (
+	id {{.PrimaryKey}},
+	name VARCHAR(64) UNIQUE NOT NULL,
+	description VARCHAR(256) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS auth_user_roles (
+	user_id BIGINT NOT NULL,
+	role_id BIGINT NOT NULL,
+	PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX auth_user_roles_role_id ON auth_user_roles (role_id);
+
+INSERT INTO auth_policies (description, table_name, action, expression)
+VALUES ('roles are limited to admin user', 'auth_roles', 'all', 'auth_user.is_admin');
+
+INSERT INTO auth_policies (description, table_name, action, expression)
+VALUES ('role assignments are limited to admin user(to deny user to assign roles to self)', 'auth_user_roles', 'all', 'auth_user.is_admin');
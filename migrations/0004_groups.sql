@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS auth_groups (
+	id {{.PrimaryKey}},
+	name VARCHAR(64) UNIQUE NOT NULL,
+	description VARCHAR(256) NOT NULL DEFAULT '',
+	parent_id BIGINT
+);
+
+CREATE TABLE IF NOT EXISTS auth_group_members (
+	group_id BIGINT NOT NULL,
+	user_id BIGINT NOT NULL,
+	PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX auth_group_members_user_id ON auth_group_members (user_id);
+
+CREATE TABLE IF NOT EXISTS auth_group_roles (
+	group_id BIGINT NOT NULL,
+	role_id BIGINT NOT NULL,
+	PRIMARY KEY (group_id, role_id)
+);
+
+INSERT INTO auth_policies (description, table_name, action, expression)
+VALUES ('groups are limited to admin user', 'auth_groups', 'all', 'auth_user.is_admin');
+
+INSERT INTO auth_policies (description, table_name, action, expression)
+VALUES ('group members are limited to admin user(to deny user to join groups)', 'auth_group_members', 'all', 'auth_user.is_admin');
+
+INSERT INTO auth_policies (description, table_name, action, expression)
+VALUES ('group roles are limited to admin user', 'auth_group_roles', 'all', 'auth_user.is_admin');
@@ -0,0 +1,6 @@
+ALTER TABLE auth_policies ADD COLUMN allowed_columns VARCHAR(1024) NOT NULL DEFAULT '';
+
+ALTER TABLE auth_policies ADD COLUMN denied_columns VARCHAR(1024) NOT NULL DEFAULT '';
+
+UPDATE auth_policies SET denied_columns = 'password'
+WHERE table_name = 'auth_users' AND denied_columns = '';
@@ -0,0 +1,6 @@
+{{/* encoded argon2id and scrypt hashes are longer than bcrypt hashes, sqlite doesn't limit the length of VARCHAR */}}
+{{if eq .Driver "postgres"}}
+ALTER TABLE auth_users ALTER COLUMN password TYPE VARCHAR(255);
+{{else if eq .Driver "mysql"}}
+ALTER TABLE auth_users MODIFY password VARCHAR(255) NOT NULL;
+{{end}}
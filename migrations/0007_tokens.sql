@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS auth_tokens (
+	id {{.PrimaryKey}},
+	user_id BIGINT NOT NULL,
+	purpose VARCHAR(32) NOT NULL,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	expires_at BIGINT NOT NULL,
+	used bool NOT NULL DEFAULT false
+);
+
+CREATE INDEX auth_tokens_user_id ON auth_tokens (user_id);
+
+INSERT INTO auth_policies (description, table_name, action, expression)
+VALUES ('one-time tokens are limited to admin user', 'auth_tokens', 'all', 'auth_user.is_admin');
@@ -0,0 +1,6 @@
+{{/* emails are stored in lower case to be unique case insensitively, NULL for users without email */}}
+ALTER TABLE auth_users ADD COLUMN email VARCHAR(255);
+
+ALTER TABLE auth_users ADD COLUMN email_verified bool NOT NULL DEFAULT false;
+
+CREATE UNIQUE INDEX auth_users_email ON auth_users (email);
@@ -0,0 +1,6 @@
+{{/* policy expressions with roles, groups and row filters are longer than 128, sqlite doesn't limit the length of VARCHAR */}}
+{{if eq .Driver "postgres"}}
+ALTER TABLE auth_policies ALTER COLUMN expression TYPE VARCHAR(1024);
+{{else if eq .Driver "mysql"}}
+ALTER TABLE auth_policies MODIFY expression VARCHAR(1024) NOT NULL;
+{{end}}
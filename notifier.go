@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Notification is a message to deliver to a user, e.g. a password reset token
+type Notification struct {
+	// Purpose is the purpose of the token, "password_reset" or
+	// "email_verification"
+	Purpose   string
+	UserID    int64
+	Username  string
+	Email     string // empty if the user has no email
+	Token     string
+	ExpiresAt time.Time
+}
+
+// Notifier delivers notifications to users, e.g. by email or SMS
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// MemoryNotifier keeps the notifications in memory, it's useful in tests
+type MemoryNotifier struct {
+	mu            sync.Mutex
+	notifications []Notification
+}
+
+// NewMemoryNotifier returns an empty MemoryNotifier
+func NewMemoryNotifier() *MemoryNotifier {
+	return &MemoryNotifier{}
+}
+
+// Notify implements Notifier interface
+func (n *MemoryNotifier) Notify(ctx context.Context, notification *Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notifications = append(n.notifications, *notification)
+	return nil
+}
+
+// Notifications returns all the notifications in the order of delivery
+func (n *MemoryNotifier) Notifications() []Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	notifications := make([]Notification, len(n.notifications))
+	copy(notifications, n.notifications)
+	return notifications
+}
+
+// Last returns the last notification of user, nil is returned if there is none
+func (n *MemoryNotifier) Last(username string) *Notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := len(n.notifications) - 1; i >= 0; i-- {
+		if n.notifications[i].Username == username {
+			notification := n.notifications[i]
+			return &notification
+		}
+	}
+	return nil
+}
+
+// LogNotifier writes the notifications to the logger, including the tokens,
+// it's only meant for development
+type LogNotifier struct {
+	Logger Logger
+}
+
+// Notify implements Notifier interface
+func (n *LogNotifier) Notify(ctx context.Context, notification *Notification) error {
+	logger := n.Logger
+	if logger == nil {
+		logger = defaultLogger{}
+	}
+	logger.Infof("notify %s token to user %s(%d): %s, expires at %s",
+		notification.Purpose, notification.Username, notification.UserID,
+		notification.Token, notification.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	j "github.com/rest-go/rest/pkg/jsonutil"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// The name of the one-time tokens table
+	OneTimeTokenTableName = "auth_tokens"
+
+	// the purpose of password reset tokens
+	purposePasswordReset = "password_reset"
+
+	createOneTimeToken = `
+		INSERT INTO auth_tokens (user_id, purpose, token_hash, expires_at)
+		VALUES (?, ?, ?, ?)
+	`
+	queryOneTimeToken = `
+		SELECT id, user_id, expires_at, used
+		FROM auth_tokens WHERE token_hash = ? AND purpose = ?
+	`
+	useOneTimeToken     = `UPDATE auth_tokens SET used = true WHERE id = ? AND used = false`
+	expireOneTimeTokens = `UPDATE auth_tokens SET used = true WHERE user_id = ? AND purpose = ? AND used = false`
+)
+
+// forgotPasswordMessage is responded whether the user exists or not
+const forgotPasswordMessage = "a password reset token is sent if the user exists"
+
+// errInvalidOneTimeToken is returned for an unknown, expired or used token
+var errInvalidOneTimeToken = errors.New("invalid or expired token")
+
+// issueOneTimeToken creates a token of purpose for the user, the previous
+// tokens of the same purpose are no longer valid
+func issueOneTimeToken(ctx context.Context, db *sql.DB, userID int64, purpose string, expiresAt time.Time) (string, error) {
+	token, err := genToken()
+	if err != nil {
+		return "", err
+	}
+	if _, dbErr := db.ExecQuery(ctx, expireOneTimeTokens, userID, purpose); dbErr != nil {
+		return "", dbErr
+	}
+	if _, dbErr := db.ExecQuery(ctx, createOneTimeToken, userID, purpose, hashToken(token), expiresAt.Unix()); dbErr != nil {
+		return "", dbErr
+	}
+	return token, nil
+}
+
+// checkOneTimeToken returns the id and the user id of a valid token of purpose
+func checkOneTimeToken(ctx context.Context, db *sql.DB, token, purpose string) (id, userID int64, err error) {
+	row, dbErr := db.FetchOne(ctx, queryOneTimeToken, hashToken(token), purpose)
+	if dbErr != nil {
+		var sqlErr sql.Error
+		if errors.As(dbErr, &sqlErr) && sqlErr.Code == http.StatusNotFound {
+			return 0, 0, errInvalidOneTimeToken
+		}
+		return 0, 0, dbErr
+	}
+	if toBool(row["used"]) || toInt64(row["expires_at"]) < time.Now().Unix() {
+		return 0, 0, errInvalidOneTimeToken
+	}
+	return toInt64(row["id"]), toInt64(row["user_id"]), nil
+}
+
+// useToken marks the token of id as used, it fails if the token is used
+// meanwhile
+func useToken(ctx context.Context, db *sql.DB, id int64) error {
+	rows, dbErr := db.ExecQuery(ctx, useOneTimeToken, id)
+	if dbErr != nil {
+		return dbErr
+	}
+	if rows == 0 {
+		return errInvalidOneTimeToken
+	}
+	return nil
+}
+
+// forgotPassword sends a password reset token to the user by the notifier,
+// the user is found by username or email. The token is issued and sent in
+// background, so that neither the response nor its timing reveals whether the
+// user exists.
+func (h *Handler) forgotPassword(r *http.Request) any {
+	if h.options.notifier == nil {
+		return &j.Response{
+			Code: http.StatusNotFound,
+			Msg:  "action not found",
+		}
+	}
+	var data struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || (data.Username == "" && data.Email == "") {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data, username or email is required",
+		}
+	}
+
+	h.tasks.Add(1)
+	go func() {
+		defer h.tasks.Done()
+		if err := h.sendPasswordReset(data.Username, data.Email); err != nil {
+			h.options.logger.Errorf("send password reset token error: %v", err)
+		}
+	}()
+	return &j.Response{Code: http.StatusOK, Msg: forgotPasswordMessage}
+}
+
+// sendPasswordReset issues a password reset token to the user of username or
+// email and sends it by the notifier, nothing is sent if the user doesn't exist
+func (h *Handler) sendPasswordReset(username, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	row, dbErr := h.fetchUserByLogin(ctx, username, email)
+	if dbErr != nil {
+		var sqlErr sql.Error
+		if errors.As(dbErr, &sqlErr) && sqlErr.Code == http.StatusNotFound {
+			return nil
+		}
+		return dbErr
+	}
+
+	userID := toInt64(row["id"])
+	expiresAt := time.Now().Add(h.options.token.ResetTTL)
+	token, err := issueOneTimeToken(ctx, h.db, userID, purposePasswordReset, expiresAt)
+	if err != nil {
+		return err
+	}
+	return h.options.notifier.Notify(ctx, &Notification{
+		Purpose:   purposePasswordReset,
+		UserID:    userID,
+		Username:  toString(row["username"]),
+		Email:     toString(row["email"]),
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// resetForgottenPassword sets the password of the user with a password reset
+// token, all the tokens of the user are revoked
+func (h *Handler) resetForgottenPassword(r *http.Request) any {
+	if h.options.notifier == nil {
+		return &j.Response{
+			Code: http.StatusNotFound,
+			Msg:  "action not found",
+		}
+	}
+	var data struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.Token == "" {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data, token is required",
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	id, userID, err := checkOneTimeToken(ctx, h.db, data.Token, purposePasswordReset)
+	if errors.Is(err, errInvalidOneTimeToken) {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
+	}
+	if err != nil {
+		h.options.logger.Errorf("check password reset token error: %v", err)
+		return j.ErrResponse(err)
+	}
+	row, dbErr := h.db.FetchOne(ctx, queryUserByID, userID)
+	if dbErr != nil {
+		return j.ErrResponse(dbErr)
+	}
+	user := newUserFromRow(row)
+	// validate the password before the token is used, so that the user can
+	// retry with another password
+	if h.options.passwordValidator != nil {
+		if err := h.options.passwordValidator(user.Username, data.Password); err != nil {
+			return newValidationResponse(err)
+		}
+	}
+	if err := useToken(ctx, h.db, id); err != nil {
+		if errors.Is(err, errInvalidOneTimeToken) {
+			return &j.Response{
+				Code: http.StatusBadRequest,
+				Msg:  err.Error(),
+			}
+		}
+		return j.ErrResponse(err)
+	}
+	if res := h.setPassword(ctx, user, data.Password); res != nil {
+		return res
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOneTimeToken(t *testing.T) {
+	ctx := context.Background()
+	token, err := issueOneTimeToken(ctx, testHandler.db, 1, purposePasswordReset, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	_, _, err = checkOneTimeToken(ctx, testHandler.db, token, "other")
+	assert.ErrorIs(t, err, errInvalidOneTimeToken)
+	id, userID, err := checkOneTimeToken(ctx, testHandler.db, token, purposePasswordReset)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), userID)
+
+	// a new token expires the previous one
+	newToken, err := issueOneTimeToken(ctx, testHandler.db, 1, purposePasswordReset, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	_, _, err = checkOneTimeToken(ctx, testHandler.db, token, purposePasswordReset)
+	assert.ErrorIs(t, err, errInvalidOneTimeToken)
+	assert.ErrorIs(t, useToken(ctx, testHandler.db, id), errInvalidOneTimeToken)
+
+	id, _, err = checkOneTimeToken(ctx, testHandler.db, newToken, purposePasswordReset)
+	assert.Nil(t, err)
+	assert.Nil(t, useToken(ctx, testHandler.db, id))
+	_, _, err = checkOneTimeToken(ctx, testHandler.db, newToken, purposePasswordReset)
+	assert.ErrorIs(t, err, errInvalidOneTimeToken)
+
+	expired, err := issueOneTimeToken(ctx, testHandler.db, 1, purposePasswordReset, time.Now().Add(-time.Second))
+	assert.Nil(t, err)
+	_, _, err = checkOneTimeToken(ctx, testHandler.db, expired, purposePasswordReset)
+	assert.ErrorIs(t, err, errInvalidOneTimeToken)
+}
+
+func TestHandlerForgotPassword(t *testing.T) {
+	notifier := NewMemoryNotifier()
+	handler, err := New(DB(testHandler.db), SigningKey(testKey), Notifications(notifier))
+	assert.Nil(t, err)
+	defer handler.Close()
+
+	status, _ := postMsg(t, handler, "/auth/register", `{"username": "forgot_user", "password": "world"}`)
+	assert.Equal(t, http.StatusOK, status)
+	status, data := serve(t, handler, http.MethodPost, "/auth/login", "", `{"username": "forgot_user", "password": "world"}`)
+	assert.Equal(t, http.StatusOK, status)
+	token := decodeResponse(t, data)["token"].(string)
+
+	t.Run("disabled without notifier", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodPost, "/auth/password/forgot", "", `{"username": "forgot_user"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/password/reset", "", `{"token": "token", "password": "hello"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		status, msg := postMsg(t, handler, "/auth/password/forgot", `{"username": "unknown_user"}`)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, forgotPasswordMessage, msg)
+		// the token is sent in background
+		handler.tasks.Wait()
+		assert.Empty(t, notifier.Notifications())
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		status, msg := postMsg(t, handler, "/auth/password/forgot", `{"username": "forgot_user"}`)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, forgotPasswordMessage, msg)
+		// the token is sent in background
+		handler.tasks.Wait()
+		n := notifier.Last("forgot_user")
+		assert.NotNil(t, n)
+		assert.Equal(t, purposePasswordReset, n.Purpose)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), n.ExpiresAt, time.Minute)
+
+		status, _ = postMsg(t, handler, "/auth/password/reset", `{"token": "invalid", "password": "hello"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		// an invalid password doesn't use the token
+		status, _ = postMsg(t, handler, "/auth/password/reset", `{"token": "`+n.Token+`", "password": ""}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _ = postMsg(t, handler, "/auth/password/reset", `{"token": "`+n.Token+`", "password": "hello"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, msg = postMsg(t, handler, "/auth/password/reset", `{"token": "`+n.Token+`", "password": "again"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, "invalid or expired token", msg)
+
+		login(t, "forgot_user", "hello")
+		// the tokens issued before are revoked
+		status, _ = serve(t, handler, http.MethodPost, "/auth/logout_all", token, "")
+		assert.Equal(t, http.StatusUnauthorized, status)
+	})
+
+	t.Run("password change expires reset tokens", func(t *testing.T) {
+		status, _ := postMsg(t, handler, "/auth/password/forgot", `{"username": "forgot_user"}`)
+		assert.Equal(t, http.StatusOK, status)
+		handler.tasks.Wait()
+		n := notifier.Last("forgot_user")
+		assert.NotNil(t, n)
+
+		token := login(t, "forgot_user", "hello")["token"]
+		body := `{"old_password": "hello", "new_password": "changed"}`
+		status, _ = serve(t, handler, http.MethodPost, "/auth/password/change", token, body)
+		assert.Equal(t, http.StatusOK, status)
+		status, msg := postMsg(t, handler, "/auth/password/reset", `{"token": "`+n.Token+`", "password": "stolen"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, "invalid or expired token", msg)
+		login(t, "forgot_user", "changed")
+	})
+}
+
+// postMsg posts body to handler and returns the response status and msg
+func postMsg(t *testing.T, handler http.Handler, path, body string) (int, any) {
+	status, data := serve(t, handler, http.MethodPost, path, "", body)
+	return status, decodeResponse(t, data)["msg"]
+}
@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"context"
+	stdSQL "database/sql"
+	"strings"
+
+	"github.com/rest-go/rest/pkg/log"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	defaultPrefix = "/auth/"
+
+	// SetupTokenEnv is the environment variable of the setup token, it's used
+	// when the SetupToken option is not provided
+	SetupTokenEnv = "AUTH_SETUP_TOKEN"
+)
+
+// Option configures Handler and Middleware
+type Option func(*options)
+
+// HookFunc is called with the user after a successful action
+type HookFunc func(ctx context.Context, user *User)
+
+// PasswordValidatorFunc validates the username and password on register, an
+// error rejects the registration
+type PasswordValidatorFunc func(username, password string) error
+
+// Logger is the logger used by Handler and Middleware, it defaults to the
+// rest-go log package. A logger with a `Debugf(format string, v ...any)`
+// method gets the debug messages as well, e.g. the explanations of the
+// requests denied by the authorization middleware.
+type Logger interface {
+	Infof(format string, v ...any)
+	Warnf(format string, v ...any)
+	Errorf(format string, v ...any)
+}
+
+// debugLogger is a Logger with debug logging on
+type debugLogger interface {
+	Debugf(format string, v ...any)
+}
+
+// debugf logs the debug message if logger has debug logging on
+func debugf(logger Logger, format string, v ...any) {
+	if l, ok := logger.(debugLogger); ok {
+		l.Debugf(format, v...)
+	}
+}
+
+type defaultLogger struct{}
+
+func (defaultLogger) Infof(format string, v ...any)  { log.Infof(format, v...) }
+func (defaultLogger) Warnf(format string, v ...any)  { log.Warnf(format, v...) }
+func (defaultLogger) Errorf(format string, v ...any) { log.Errorf(format, v...) }
+
+type options struct {
+	prefix               string
+	dbURL                string
+	db                   *sql.DB
+	key                  SignerVerifier
+	logger               Logger
+	revocations          RevocationStore
+	policies             *PolicyStore
+	routes               RouteFunc
+	token                TokenOptions
+	claimsBuilder        ClaimsBuilderFunc
+	claimsParser         ClaimsParserFunc
+	passwordValidator    PasswordValidatorFunc
+	hasher               PasswordHasher
+	notifier             Notifier
+	requireVerifiedEmail bool
+	afterRegister        HookFunc
+	afterLogin           HookFunc
+	disableSetup         bool
+	setupToken           string
+	adminUsername        string
+	adminPassword        string
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		prefix:            defaultPrefix,
+		logger:            defaultLogger{},
+		passwordValidator: (&PasswordPolicy{}).Validate,
+		hasher:            DefaultPasswordHasher,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.token = o.token.withDefaults()
+	return o
+}
+
+// Prefix sets the route prefix of Handler, default to `/auth/`
+func Prefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = "/" + strings.Trim(prefix, "/") + "/"
+		if o.prefix == "//" {
+			o.prefix = "/"
+		}
+	}
+}
+
+// DBURL sets the url of database to open for Handler, e.g. sqlite://my.db
+func DBURL(url string) Option {
+	return func(o *options) {
+		o.dbURL = url
+	}
+}
+
+// DB sets an opened database for Handler, it takes precedence over DBURL.
+// Handler doesn't close the database it doesn't open.
+func DB(db *sql.DB) Option {
+	return func(o *options) {
+		o.db = db
+	}
+}
+
+// StdDB sets an opened database/sql DB for Handler, driverName is one of
+// postgres, mysql and sqlite
+func StdDB(db *stdSQL.DB, driverName string) Option {
+	return DB(&sql.DB{DB: db, DriverName: driverName})
+}
+
+// SigningKey sets the key to sign and verify tokens for Handler
+func SigningKey(key SignerVerifier) Option {
+	return func(o *options) {
+		o.key = key
+	}
+}
+
+// Logging sets the logger for Handler, Middleware, the stores and migrations
+func Logging(logger Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// Revocations sets the store to check revoked tokens, Middleware doesn't check
+// revoked tokens without it. Handler.RevocationStore returns the store used by
+// Handler.
+func Revocations(store RevocationStore) Option {
+	return func(o *options) {
+		o.revocations = store
+	}
+}
+
+// Policies sets the store of policies for Handler, Handler creates one without
+// polling, which reloads policies only after they're changed by the Handler.
+// Use a polling store to pick up the changes made by other instances.
+// Handler.PolicyStore returns the store used by Handler.
+func Policies(store *PolicyStore) Option {
+	return func(o *options) {
+		o.policies = store
+	}
+}
+
+// Routes sets the function mapping requests to tables and actions for the
+// authorization middleware, default to RESTRoutes("/")
+func Routes(f RouteFunc) Option {
+	return func(o *options) {
+		o.routes = f
+	}
+}
+
+// ClaimsBuilder sets the function to build custom claims for Handler
+func ClaimsBuilder(f ClaimsBuilderFunc) Option {
+	return func(o *options) {
+		o.claimsBuilder = f
+	}
+}
+
+// ClaimsParser sets the function to parse custom claims for Middleware
+func ClaimsParser(f ClaimsParserFunc) Option {
+	return func(o *options) {
+		o.claimsParser = f
+	}
+}
+
+// Tokens sets the options of issuing and validating tokens, Handler and
+// Middleware should share the same Issuer and Audience
+func Tokens(tokenOptions TokenOptions) Option {
+	return func(o *options) {
+		o.token = tokenOptions
+	}
+}
+
+// PasswordValidator sets the function to validate username and password on
+// register, it defaults to the Validate method of a zero PasswordPolicy. A
+// *ValidationError returned is responded with every violation.
+func PasswordValidator(f PasswordValidatorFunc) Option {
+	return func(o *options) {
+		o.passwordValidator = f
+	}
+}
+
+// Hasher sets the password hasher of Handler, it defaults to
+// DefaultPasswordHasher. Passwords hashed by another builtin hasher or with
+// other parameters are rehashed when the users log in.
+func Hasher(hasher PasswordHasher) Option {
+	return func(o *options) {
+		o.hasher = hasher
+	}
+}
+
+// Notifications sets the notifier to deliver tokens to users, the forgot
+// password and email verification endpoints are enabled with it
+func Notifications(notifier Notifier) Option {
+	return func(o *options) {
+		o.notifier = notifier
+	}
+}
+
+// RequireVerifiedEmail requires an email on register, and blocks the login of
+// users whose email is not verified. Users without email, e.g. the admin user
+// created by setup, can still log in. It requires the Notifications option to
+// deliver the verification tokens.
+func RequireVerifiedEmail() Option {
+	return func(o *options) {
+		o.requireVerifiedEmail = true
+	}
+}
+
+// AfterRegister sets the hook called after a user is registered
+func AfterRegister(f HookFunc) Option {
+	return func(o *options) {
+		o.afterRegister = f
+	}
+}
+
+// DisableSetup disables the setup endpoint of Handler, it responds 404 as if
+// the endpoint doesn't exist. Use Setup or SetupWithAdmin to provision the
+// database instead.
+func DisableSetup() Option {
+	return func(o *options) {
+		o.disableSetup = true
+	}
+}
+
+// SetupToken requires the setup request to provide token in the
+// `X-Setup-Token` header, it defaults to the AUTH_SETUP_TOKEN environment
+// variable
+func SetupToken(token string) Option {
+	return func(o *options) {
+		o.setupToken = token
+	}
+}
+
+// SetupAdmin sets the username and password of the admin user created by
+// setup, a username and password in the setup request take precedence
+func SetupAdmin(username, password string) Option {
+	return func(o *options) {
+		o.adminUsername = username
+		o.adminPassword = password
+	}
+}
+
+// AfterLogin sets the hook called after a user is logged in
+func AfterLogin(f HookFunc) Option {
+	return func(o *options) {
+		o.afterLogin = f
+	}
+}
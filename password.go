@@ -0,0 +1,228 @@
+package auth
+
+import (
+	_ "embed"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// bcrypt ignores the bytes after the first 72 bytes of a password
+	maxPasswordLength = 72
+	// the size of the username column
+	maxUsernameLength = 32
+	// the size of the email column
+	maxEmailLength = 255
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var (
+	commonPasswordsOnce sync.Once
+	commonPasswords     map[string]struct{}
+)
+
+// isCommonPassword returns whether password is in the embedded list of common
+// passwords, case insensitively
+func isCommonPassword(password string) bool {
+	commonPasswordsOnce.Do(func() {
+		lines := strings.Split(commonPasswordList, "\n")
+		commonPasswords = make(map[string]struct{}, len(lines))
+		for _, line := range lines {
+			if line = strings.TrimSpace(line); line != "" {
+				commonPasswords[strings.ToLower(line)] = struct{}{}
+			}
+		}
+	})
+	_, ok := commonPasswords[strings.ToLower(password)]
+	return ok
+}
+
+// Violation is a rule violated by the username or password
+type Violation struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Msg   string `json:"msg"`
+}
+
+// ValidationError is returned by PasswordPolicy.Validate with every violated
+// rule, Handler responds it as a 400 response with the violations in `errors`
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Msg
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) add(field, rule, format string, args ...any) {
+	e.Violations = append(e.Violations, Violation{
+		Field: field,
+		Rule:  rule,
+		Msg:   fmt.Sprintf(format, args...),
+	})
+}
+
+// PasswordPolicy validates the username and password on register. The zero
+// value only requires a non empty username of at most 32 characters and a non
+// empty password of at most 72 bytes, which is the limit of bcrypt. Use
+// `auth.PasswordValidator(policy.Validate)` to set it for Handler.
+type PasswordPolicy struct {
+	// MinLength and MaxLength limit the bytes of password, MaxLength is at
+	// most 72
+	MinLength int
+	MaxLength int
+	// Require at least one character of the classes
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// CheckCommon rejects the common passwords in the embedded list, and
+	// Blocklist rejects the passwords in it whether CheckCommon is set or
+	// not, both case insensitively
+	CheckCommon bool
+	Blocklist   []string
+	// CheckUsername rejects a password containing the username or its
+	// reverse, or contained by the username
+	CheckUsername bool
+
+	// UsernameMinLength and UsernameMaxLength limit the characters of
+	// username, UsernameMaxLength is at most 32
+	UsernameMinLength int
+	UsernameMaxLength int
+	// UsernamePattern is the pattern username must match if it's not nil
+	UsernamePattern *regexp.Regexp
+}
+
+// RecommendedPasswordPolicy returns a policy requiring passwords of at least 8
+// bytes which are not common or similar to the username, and usernames of 3
+// to 32 letters, digits and `_.-`
+func RecommendedPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{
+		MinLength:         8,
+		CheckCommon:       true,
+		CheckUsername:     true,
+		UsernameMinLength: 3,
+		UsernamePattern:   regexp.MustCompile(`^[A-Za-z0-9_.-]+$`),
+	}
+}
+
+// Validate returns a *ValidationError with every rule violated by username
+// and password, it implements PasswordValidatorFunc
+func (p *PasswordPolicy) Validate(username, password string) error {
+	verr := &ValidationError{}
+	p.validateUsername(verr, username)
+	p.validatePassword(verr, username, password)
+	if len(verr.Violations) > 0 {
+		return verr
+	}
+	return nil
+}
+
+func (p *PasswordPolicy) validateUsername(verr *ValidationError, username string) {
+	minLength := p.UsernameMinLength
+	if minLength < 1 {
+		minLength = 1
+	}
+	maxLength := p.UsernameMaxLength
+	if maxLength <= 0 || maxLength > maxUsernameLength {
+		maxLength = maxUsernameLength
+	}
+	length := utf8.RuneCountInString(username)
+	if length < minLength {
+		verr.add("username", "min_length", "username must be at least %d characters", minLength)
+	}
+	if length > maxLength {
+		verr.add("username", "max_length", "username must be at most %d characters", maxLength)
+	}
+	if p.UsernamePattern != nil && username != "" && !p.UsernamePattern.MatchString(username) {
+		verr.add("username", "pattern", "username must match %s", p.UsernamePattern)
+	}
+}
+
+func (p *PasswordPolicy) validatePassword(verr *ValidationError, username, password string) {
+	minLength := p.MinLength
+	if minLength < 1 {
+		minLength = 1
+	}
+	maxLength := p.MaxLength
+	if maxLength <= 0 || maxLength > maxPasswordLength {
+		maxLength = maxPasswordLength
+	}
+	if len(password) < minLength {
+		verr.add("password", "min_length", "password must be at least %d bytes", minLength)
+	}
+	if len(password) > maxLength {
+		verr.add("password", "max_length", "password must be at most %d bytes", maxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			symbol = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		verr.add("password", "upper", "password must contain an upper case letter")
+	}
+	if p.RequireLower && !lower {
+		verr.add("password", "lower", "password must contain a lower case letter")
+	}
+	if p.RequireDigit && !digit {
+		verr.add("password", "digit", "password must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		verr.add("password", "symbol", "password must contain a symbol")
+	}
+
+	if password != "" && p.isBlocked(password) {
+		verr.add("password", "common", "password is too common")
+	}
+	if p.CheckUsername && isSimilar(username, password) {
+		verr.add("password", "username", "password is too similar to the username")
+	}
+}
+
+func (p *PasswordPolicy) isBlocked(password string) bool {
+	if p.CheckCommon && isCommonPassword(password) {
+		return true
+	}
+	for _, blocked := range p.Blocklist {
+		if strings.EqualFold(password, blocked) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSimilar returns whether password contains the username or its reverse,
+// or is contained by the username, case insensitively
+func isSimilar(username, password string) bool {
+	if username == "" || password == "" {
+		return false
+	}
+	username, password = strings.ToLower(username), strings.ToLower(password)
+	runes := []rune(username)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return strings.Contains(password, username) ||
+		strings.Contains(password, string(runes)) ||
+		strings.Contains(username, password)
+}
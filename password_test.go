@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func violatedRules(err error) []string {
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		return nil
+	}
+	rules := make([]string, len(verr.Violations))
+	for i, v := range verr.Violations {
+		rules[i] = v.Field + "." + v.Rule
+	}
+	return rules
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	t.Run("zero value", func(t *testing.T) {
+		p := &PasswordPolicy{}
+		assert.Nil(t, p.Validate("hello", "world"))
+		assert.Equal(t, []string{"username.min_length", "password.min_length"}, violatedRules(p.Validate("", "")))
+		assert.Equal(t, []string{"username.max_length", "password.max_length"},
+			violatedRules(p.Validate(strings.Repeat("u", 33), strings.Repeat("p", 73))))
+		// the max length can't exceed the limit of bcrypt
+		p = &PasswordPolicy{MaxLength: 100}
+		assert.Equal(t, []string{"password.max_length"}, violatedRules(p.Validate("hello", strings.Repeat("p", 73))))
+	})
+
+	t.Run("character classes", func(t *testing.T) {
+		p := &PasswordPolicy{RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+		assert.Equal(t, []string{"password.upper", "password.digit", "password.symbol"}, violatedRules(p.Validate("hello", "world")))
+		assert.Nil(t, p.Validate("hello", "Wor1d!"))
+	})
+
+	t.Run("recommended", func(t *testing.T) {
+		p := RecommendedPasswordPolicy()
+		p.Blocklist = []string{"Correct-Horse"}
+		assert.Nil(t, p.Validate("alice", "battery-staple"))
+		assert.Equal(t, []string{"password.common"}, violatedRules(p.Validate("alice", "Password123")))
+		assert.Equal(t, []string{"password.common"}, violatedRules(p.Validate("alice", "correct-horse")))
+		assert.Equal(t, []string{"password.username"}, violatedRules(p.Validate("alice", "alice-in-wonderland")))
+		assert.Equal(t, []string{"password.username"}, violatedRules(p.Validate("alice", "ecila-reversed")))
+		assert.Equal(t, []string{"username.min_length", "username.pattern", "password.min_length"},
+			violatedRules(p.Validate("a!", "short")))
+	})
+
+	t.Run("blocklist", func(t *testing.T) {
+		p := &PasswordPolicy{Blocklist: []string{"Correct-Horse"}}
+		assert.Equal(t, []string{"password.common"}, violatedRules(p.Validate("alice", "correct-horse")))
+		// the embedded list is not checked without CheckCommon
+		assert.Nil(t, p.Validate("alice", "Password123"))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		err := (&PasswordPolicy{MinLength: 8, RequireDigit: true}).Validate("hello", "world")
+		assert.Equal(t, "password must be at least 8 bytes; password must contain a digit", err.Error())
+	})
+}
+
+func TestHandlerPasswordPolicy(t *testing.T) {
+	handler, err := New(DB(testHandler.db), SigningKey(testKey), PasswordValidator(RecommendedPasswordPolicy().Validate))
+	assert.Nil(t, err)
+	defer handler.Close()
+
+	register := func(body string) (int, map[string]any) {
+		status, data := serve(t, handler, http.MethodPost, "/auth/register", "", body)
+		return status, decodeResponse(t, data)
+	}
+
+	code, data := register(`{"username": "pa", "password": "pass"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "username must be at least 3 characters; password must be at least 8 bytes; password is too common; "+
+		"password is too similar to the username", data["msg"])
+	assert.Len(t, data["errors"], 4)
+	assert.Equal(t, map[string]any{
+		"field": "username",
+		"rule":  "min_length",
+		"msg":   "username must be at least 3 characters",
+	}, data["errors"].([]any)[0])
+
+	code, _ = register(`{"username": "password_policy", "password": "battery-staple"}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	// the default policy rejects an empty password
+	code, _ = serve(t, testHandler, http.MethodPost, "/auth/register", "", `{"username": "empty_password", "password": ""}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+// decodeResponse decodes the json response data of serve
+func decodeResponse(t *testing.T, data []byte) map[string]any {
+	res := map[string]any{}
+	assert.Nil(t, json.Unmarshal(data, &res))
+	return res
+}
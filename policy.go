@@ -2,9 +2,16 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/rest-go/rest/pkg/log"
+	j "github.com/rest-go/rest/pkg/jsonutil"
 	"github.com/rest-go/rest/pkg/sql"
 )
 
@@ -12,19 +19,33 @@ const (
 	// the name of the policies table
 	PolicyTableName = "auth_policies"
 
-	createPolicyTable = `
-	CREATE TABLE auth_policies (
-		id %s,
-		description VARCHAR(256) NOT NULL,
-		table_name VARCHAR(128) NOT NULL,
-		action VARCHAR(16) NOT NULL,
-		expression VARCHAR(128) NOT NULL
-	)
+	queryPolicies = `
+		SELECT id, description, table_name, action, expression, allowed_columns, denied_columns
+		FROM auth_policies ORDER BY id
 	`
+
+	// DefaultPolicyRefreshInterval is how often PolicyStore reloads policies
+	DefaultPolicyRefreshInterval = 30 * time.Second
+
+	// the sizes of the description and expression columns
+	maxPolicyDescriptionLength = 256
+	maxPolicyExpressionLength  = 1024
+
 	createInternalPolicy = `
-		INSERT INTO auth_policies (description, table_name, action, expression)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO auth_policies (description, table_name, action, expression, allowed_columns, denied_columns)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	queryPolicy = `
+		SELECT id, description, table_name, action, expression, allowed_columns, denied_columns
+		FROM auth_policies WHERE id = ?
 	`
+	queryPolicyByAction = `SELECT id FROM auth_policies WHERE table_name = ? AND action = ?`
+	updatePolicy        = `
+		UPDATE auth_policies
+		SET description = ?, table_name = ?, action = ?, expression = ?, allowed_columns = ?, denied_columns = ?
+		WHERE id = ?
+	`
+	deletePolicy = `DELETE FROM auth_policies WHERE id = ?`
 )
 
 var defaultPolicies = []Policy{
@@ -35,10 +56,11 @@ var defaultPolicies = []Policy{
 		Expression:  "auth_user.is_admin",
 	},
 	{
-		Description: "users are limited to admin user(to deny user to update self to admin)",
-		TableName:   "auth_users",
-		Action:      "all",
-		Expression:  "auth_user.is_admin",
+		Description:   "users are limited to admin user(to deny user to update self to admin)",
+		TableName:     "auth_users",
+		Action:        "all",
+		Expression:    "auth_user.is_admin",
+		DeniedColumns: []string{"password"},
 	},
 	{
 		Description: "all tables/actions are limited to be filtered by user_id",
@@ -48,28 +70,75 @@ var defaultPolicies = []Policy{
 	},
 }
 
-// Policy represents a security policy against a table
+// Policy represents a security policy against a table, AllowedColumns and
+// DeniedColumns limit the columns the action can read or write, all the
+// columns are allowed if AllowedColumns is empty
 type Policy struct {
-	ID          int64  `json:"id"`
-	Description string `json:"description"`
-	TableName   string `json:"table_name"`
-	Action      string `json:"action"`
-	Expression  string `json:"expression"`
-}
-
-// setupPolicies create `policies` table and create a default internal policies
-func setupPolicies(db *sql.DB) error {
-	log.Info("create policies table")
-	idSQL := primaryKeySQL[db.DriverName]
-	createTableQuery := fmt.Sprintf(createPolicyTable, idSQL)
+	ID             int64    `json:"id"`
+	Description    string   `json:"description"`
+	TableName      string   `json:"table_name"`
+	Action         string   `json:"action"`
+	Expression     string   `json:"expression"`
+	AllowedColumns []string `json:"allowed_columns,omitempty"`
+	DeniedColumns  []string `json:"denied_columns,omitempty"`
+}
+
+func newPolicyFromRow(row map[string]any) Policy {
+	return Policy{
+		ID:             toInt64(row["id"]),
+		Description:    toString(row["description"]),
+		TableName:      toString(row["table_name"]),
+		Action:         toString(row["action"]),
+		Expression:     toString(row["expression"]),
+		AllowedColumns: splitColumns(toString(row["allowed_columns"])),
+		DeniedColumns:  splitColumns(toString(row["denied_columns"])),
+	}
+}
+
+// Validate returns an error if the policy is incomplete or its expression is
+// invalid, an expression is required so that a policy never allows everyone
+// by accident, use `true` to allow everyone explicitly
+func (p *Policy) Validate() error {
+	if p.TableName == "" {
+		return errors.New("table_name is required")
+	}
+	if p.Action == "" {
+		return errors.New("action is required")
+	}
+	if _, err := ParseAction(p.Action); err != nil && p.Action != "all" {
+		return err
+	}
+	if len(p.Description) > maxPolicyDescriptionLength {
+		return fmt.Errorf("description must be at most %d bytes", maxPolicyDescriptionLength)
+	}
+	if strings.TrimSpace(p.Expression) == "" {
+		return errors.New("expression is required")
+	}
+	if len(p.Expression) > maxPolicyExpressionLength {
+		return fmt.Errorf("expression must be at most %d bytes", maxPolicyExpressionLength)
+	}
+	if err := ValidateExpression(p.Expression); err != nil {
+		return fmt.Errorf("invalid expression: %w", err)
+	}
+	for _, column := range append(p.AllowedColumns, p.DeniedColumns...) {
+		if !columnExp.MatchString(column) {
+			return fmt.Errorf("invalid column name: %q", column)
+		}
+	}
+	return nil
+}
+
+// Columns returns the set of columns permitted by the policy
+func (p *Policy) Columns() *ColumnSet {
+	return NewColumnSet(p.AllowedColumns, p.DeniedColumns)
+}
+
+// setupPolicies create a default internal policies
+func setupPolicies(db *sql.DB, logger Logger) error {
 	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
 	defer cancel()
-	_, dbErr := db.ExecQuery(ctx, createTableQuery)
-	if dbErr != nil {
-		return dbErr
-	}
 
-	log.Info("create default policies")
+	logger.Infof("create default policies")
 	for _, policy := range defaultPolicies {
 		_, dbErr := db.ExecQuery(
 			ctx,
@@ -78,6 +147,8 @@ func setupPolicies(db *sql.DB) error {
 			policy.TableName,
 			policy.Action,
 			policy.Expression,
+			joinColumns(policy.AllowedColumns),
+			joinColumns(policy.DeniedColumns),
 		)
 		if dbErr != nil {
 			return dbErr
@@ -85,3 +156,329 @@ func setupPolicies(db *sql.DB) error {
 	}
 	return nil
 }
+
+// PolicyStore loads policies from the auth_policies table and caches them in
+// memory, the cache is reloaded periodically and on the first read after
+// Invalidate
+type PolicyStore struct {
+	db       *sql.DB
+	interval time.Duration
+	logger   Logger
+
+	mu       sync.RWMutex
+	policies []Policy
+	perms    map[string]map[string]string
+	index    map[string]map[string]*Policy // table name -> action -> policy
+	stale    bool
+	// generation is bumped by Invalidate, a Refresh started before an
+	// Invalidate doesn't clear stale
+	generation uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPolicyStore returns a PolicyStore which reloads policies every interval,
+// 0 means DefaultPolicyRefreshInterval and a negative interval disables
+// polling, call Close to stop polling. The Logging option sets the logger of
+// the store.
+func NewPolicyStore(db *sql.DB, interval time.Duration, opts ...Option) *PolicyStore {
+	if interval == 0 {
+		interval = DefaultPolicyRefreshInterval
+	}
+	s := &PolicyStore{
+		db:       db,
+		interval: interval,
+		logger:   newOptions(opts).logger,
+		stale:    true,
+		done:     make(chan struct{}),
+	}
+	if interval > 0 {
+		go s.poll()
+	}
+	return s
+}
+
+func (s *PolicyStore) poll() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+			if err := s.Refresh(ctx); err != nil {
+				s.logger.Warnf("refresh policies error: %v", err)
+			}
+			cancel()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Refresh reloads policies from database, the cached policies are kept if
+// it fails
+func (s *PolicyStore) Refresh(ctx context.Context) error {
+	s.mu.RLock()
+	generation := s.generation
+	s.mu.RUnlock()
+	rows, dbErr := s.db.FetchData(ctx, queryPolicies)
+	if dbErr != nil {
+		return dbErr
+	}
+	s.install(generation, rows)
+	return nil
+}
+
+// install replaces the cached policies with the rows fetched at generation,
+// the policies stay stale if they are invalidated since then
+func (s *PolicyStore) install(generation uint64, rows []map[string]any) {
+	policies := make([]Policy, 0, len(rows))
+	perms := make(map[string]map[string]string)
+	for _, row := range rows {
+		policy := newPolicyFromRow(row)
+		if err := policy.Validate(); err != nil {
+			// the policy is still loaded to deny the action rather than
+			// falling back to a less strict policy, an empty expression
+			// would allow everyone otherwise
+			s.logger.Errorf("invalid policy %d: %v, deny the action", policy.ID, err)
+			policy.Expression = "false"
+		}
+		policies = append(policies, policy)
+		if _, ok := perms[policy.TableName]; !ok {
+			perms[policy.TableName] = make(map[string]string)
+		}
+		perms[policy.TableName][policy.Action] = policy.Expression
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies = policies
+	s.perms = perms
+	s.index = indexPolicies(policies)
+	s.stale = s.generation != generation
+}
+
+// Invalidate marks the cached policies as stale, they are reloaded on the
+// next read, call it after policies are changed in database
+func (s *PolicyStore) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stale = true
+	s.generation++
+}
+
+// load reloads the policies if they are stale
+func (s *PolicyStore) load() {
+	s.mu.RLock()
+	stale := s.stale
+	s.mu.RUnlock()
+	if !stale {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	if err := s.Refresh(ctx); err != nil {
+		s.logger.Errorf("load policies error: %v", err)
+	}
+}
+
+// Policies returns the policies in the structure User.HasPerm expects, which
+// is table name -> action -> expression. The returned map must not be
+// modified.
+func (s *PolicyStore) Policies() map[string]map[string]string {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.perms
+}
+
+// List returns all the policies ordered by id
+func (s *PolicyStore) List() []Policy {
+	s.load()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	policies := make([]Policy, len(s.policies))
+	copy(policies, s.policies)
+	return policies
+}
+
+// HasPerm check whether user has permission to perform action on the table
+// with the stored policies
+func (s *PolicyStore) HasPerm(user *User, table string, action Action) (hasPerm bool, withUserIDColumn string) {
+	return user.tablePerm(table, action, s.Policies(), s.logger)
+}
+
+// RowFilter returns the row filter of user on the table for action with the
+// stored policies
+func (s *PolicyStore) RowFilter(user *User, table string, action Action) (*RowFilter, error) {
+	return user.rowFilter(s.db.DriverName, table, action, s.Policies(), s.logger)
+}
+
+// PermittedColumns returns whether user has permission to perform action on
+// the table and the columns permitted with the stored policies, see
+// User.PermittedColumns
+func (s *PolicyStore) PermittedColumns(user *User, table string, action Action) (hasPerm bool, withUserIDColumn string, columns *ColumnSet) {
+	s.load()
+	s.mu.RLock()
+	index := s.index
+	s.mu.RUnlock()
+	return user.permittedColumns(table, action, index, s.logger)
+}
+
+// Close stops polling, it's safe to call it multiple times
+func (s *PolicyStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
+
+// policies serves the endpoints of policies, they are guarded by the policies
+// on the auth_policies table, which are limited to admin user by default
+//
+//	GET    policies      list policies
+//	POST   policies      create a policy
+//	GET    policies/<id> get a policy
+//	PUT    policies/<id> update a policy
+//	DELETE policies/<id> delete a policy
+func (h *Handler) policies(r *http.Request, action string) any {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+
+	var id int64
+	if _, v, ok := strings.Cut(action, "/"); ok {
+		var err error
+		if id, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return &j.Response{
+				Code: http.StatusNotFound,
+				Msg:  fmt.Sprintf("policy not found: %s", v),
+			}
+		}
+	}
+	var policyAction Action
+	switch {
+	case r.Method == http.MethodGet:
+		policyAction = ActionRead
+	case r.Method == http.MethodPost && id == 0:
+		policyAction = ActionCreate
+	case r.Method == http.MethodPut && id != 0:
+		policyAction = ActionUpdate
+	case r.Method == http.MethodDelete && id != 0:
+		policyAction = ActionDelete
+	default:
+		return &j.Response{
+			Code: http.StatusMethodNotAllowed,
+			Msg:  fmt.Sprintf("method not supported: %s", r.Method),
+		}
+	}
+
+	user, res := h.requireUser(ctx, r)
+	if res != nil {
+		return res
+	}
+	// a policy limiting rows by user id doesn't apply to the policies table
+	if hasPerm, userIDColumn := h.options.policies.HasPerm(user, PolicyTableName, policyAction); !hasPerm || userIDColumn != "" {
+		return &j.Response{
+			Code: http.StatusForbidden,
+			Msg:  "unauthorized",
+		}
+	}
+
+	switch policyAction {
+	case ActionRead:
+		if id != 0 {
+			return h.fetchPolicy(ctx, id)
+		}
+		rows, dbErr := h.db.FetchData(ctx, queryPolicies)
+		if dbErr != nil {
+			h.options.logger.Errorf("fetch policies error: %v", dbErr)
+			return j.ErrResponse(dbErr)
+		}
+		policies := make([]Policy, 0, len(rows))
+		for _, row := range rows {
+			policies = append(policies, newPolicyFromRow(row))
+		}
+		return policies
+	case ActionCreate, ActionUpdate:
+		return h.savePolicy(ctx, r, id)
+	default:
+		n, dbErr := h.db.ExecQuery(ctx, deletePolicy, id)
+		if dbErr != nil {
+			h.options.logger.Errorf("delete policy error: %v", dbErr)
+			return j.ErrResponse(dbErr)
+		}
+		if n == 0 {
+			return &j.Response{
+				Code: http.StatusNotFound,
+				Msg:  fmt.Sprintf("policy not found: %d", id),
+			}
+		}
+		h.options.policies.Invalidate()
+		return &j.Response{Code: http.StatusOK, Msg: "success"}
+	}
+}
+
+func (h *Handler) fetchPolicy(ctx context.Context, id int64) any {
+	row, dbErr := h.db.FetchOne(ctx, queryPolicy, id)
+	if dbErr != nil {
+		return j.ErrResponse(dbErr)
+	}
+	return newPolicyFromRow(row)
+}
+
+// savePolicy creates a policy if id is 0, or updates the policy of id. There
+// is at most one policy for each table and action.
+func (h *Handler) savePolicy(ctx context.Context, r *http.Request, id int64) any {
+	policy := &Policy{}
+	if err := json.NewDecoder(r.Body).Decode(policy); err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data",
+		}
+	}
+	if err := policy.Validate(); err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
+	}
+
+	if id != 0 {
+		if _, dbErr := h.db.FetchOne(ctx, queryPolicy, id); dbErr != nil {
+			return j.ErrResponse(dbErr)
+		}
+	}
+	rows, dbErr := h.db.FetchData(ctx, queryPolicyByAction, policy.TableName, policy.Action)
+	if dbErr != nil {
+		return j.ErrResponse(dbErr)
+	}
+	for _, row := range rows {
+		if toInt64(row["id"]) != id {
+			return &j.Response{
+				Code: http.StatusConflict,
+				Msg:  fmt.Sprintf("policy of %s on %s already exists", policy.Action, policy.TableName),
+			}
+		}
+	}
+
+	args := []any{
+		policy.Description,
+		policy.TableName,
+		policy.Action,
+		policy.Expression,
+		joinColumns(policy.AllowedColumns),
+		joinColumns(policy.DeniedColumns),
+	}
+	query := createInternalPolicy
+	if id != 0 {
+		query = updatePolicy
+		args = append(args, id)
+	}
+	if _, dbErr := h.db.ExecQuery(ctx, query, args...); dbErr != nil {
+		h.options.logger.Errorf("save policy error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+	h.options.policies.Invalidate()
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
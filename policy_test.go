@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewPolicyStore(testHandler.db, -1)
+	defer store.Close()
+	rows, err := testHandler.db.FetchData(ctx, queryPolicies)
+	assert.Nil(t, err)
+
+	t.Run("load", func(t *testing.T) {
+		policies := store.Policies()
+		assert.Equal(t, "auth_user.is_admin", policies["auth_policies"]["all"])
+		assert.Equal(t, "user_id = auth_user.id", policies["all"]["all"])
+		assert.Equal(t, "auth_user.is_admin", policies["auth_user_roles"]["all"])
+
+		hasPerm, _, columns := store.PermittedColumns(&User{ID: 1, IsAdmin: true}, "auth_users", ActionRead)
+		assert.True(t, hasPerm)
+		assert.False(t, columns.Permits("password"))
+		assert.True(t, columns.Permits("username"))
+		assert.Len(t, store.List(), len(rows))
+
+		user := &User{ID: 1}
+		hasPerm, _ = store.HasPerm(user, "auth_users", ActionRead)
+		assert.False(t, hasPerm)
+		hasPerm, userIDColumn := store.HasPerm(user, "todos", ActionRead)
+		assert.True(t, hasPerm)
+		assert.Equal(t, "user_id", userIDColumn)
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		_, err := testHandler.db.ExecQuery(ctx, createInternalPolicy, "public todos", "todos", "read", "true", "", "")
+		assert.Nil(t, err)
+		defer func() {
+			_, err := testHandler.db.ExecQuery(ctx, "DELETE FROM auth_policies WHERE table_name = 'todos'")
+			assert.Nil(t, err)
+		}()
+
+		// cached until invalidated
+		_, ok := store.Policies()["todos"]
+		assert.False(t, ok)
+		store.Invalidate()
+		assert.Equal(t, "true", store.Policies()["todos"]["read"])
+		hasPerm, userIDColumn := store.HasPerm(&User{}, "todos", ActionRead)
+		assert.True(t, hasPerm)
+		assert.Equal(t, "", userIDColumn)
+	})
+
+	t.Run("invalid policies deny", func(t *testing.T) {
+		_, err := testHandler.db.ExecQuery(ctx, createInternalPolicy, "empty", "todos", "read", "", "", "")
+		assert.Nil(t, err)
+		_, err = testHandler.db.ExecQuery(ctx, createInternalPolicy, "invalid", "todos", "update", "public =", "", "")
+		assert.Nil(t, err)
+		defer func() {
+			_, err := testHandler.db.ExecQuery(ctx, "DELETE FROM auth_policies WHERE table_name = 'todos'")
+			assert.Nil(t, err)
+			store.Invalidate()
+		}()
+
+		store.Invalidate()
+		assert.Equal(t, "false", store.Policies()["todos"]["read"])
+		hasPerm, _ := store.HasPerm(&User{ID: 1}, "todos", ActionRead)
+		assert.False(t, hasPerm)
+		hasPerm, _, _ = store.PermittedColumns(&User{ID: 1}, "todos", ActionUpdate)
+		assert.False(t, hasPerm)
+		filter, err := store.RowFilter(&User{ID: 1}, "todos", ActionRead)
+		assert.Nil(t, err)
+		assert.False(t, filter.Allowed())
+	})
+
+	t.Run("invalidate during refresh", func(t *testing.T) {
+		store.Invalidate()
+		store.mu.RLock()
+		generation := store.generation
+		store.mu.RUnlock()
+		// rows fetched before a write are installed after its Invalidate
+		store.Invalidate()
+		store.install(generation, rows)
+		store.mu.RLock()
+		defer store.mu.RUnlock()
+		assert.True(t, store.stale)
+	})
+
+	t.Run("logger", func(t *testing.T) {
+		logger := &testDebugLogger{}
+		store := NewPolicyStore(testHandler.db, -1, Logging(logger))
+		defer store.Close()
+		_, err := testHandler.db.ExecQuery(ctx, createInternalPolicy, "public todos", "todos", "read", "public", "", "")
+		assert.Nil(t, err)
+		defer func() {
+			_, err := testHandler.db.ExecQuery(ctx, "DELETE FROM auth_policies WHERE table_name = 'todos'")
+			assert.Nil(t, err)
+		}()
+
+		hasPerm, _ := store.HasPerm(&User{ID: 1}, "todos", ActionRead)
+		assert.False(t, hasPerm)
+		assert.Len(t, logger.debugs, 1)
+		assert.Contains(t, logger.debugs[0], "policy exp: public depends on columns other than user id")
+	})
+
+	t.Run("poll", func(t *testing.T) {
+		store := NewPolicyStore(testHandler.db, 10*time.Millisecond)
+		defer store.Close()
+		assert.Len(t, store.List(), len(rows))
+
+		_, err := testHandler.db.ExecQuery(ctx, createInternalPolicy, "public notes", "notes", "read", "true", "", "")
+		assert.Nil(t, err)
+		defer func() {
+			_, err := testHandler.db.ExecQuery(ctx, "DELETE FROM auth_policies WHERE table_name = 'notes'")
+			assert.Nil(t, err)
+		}()
+		assert.Eventually(t, func() bool {
+			_, ok := store.Policies()["notes"]
+			return ok
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestHandlerPolicies(t *testing.T) {
+	status, _ := serve(t, testHandler, http.MethodPost, "/auth/register", "", `{"username": "policy_user", "password": "world"}`)
+	assert.Equal(t, http.StatusOK, status)
+	userToken := login(t, "policy_user", "world")["token"]
+	token := adminToken(t)
+	store := testHandler.PolicyStore()
+
+	t.Run("admin required", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodGet, "/auth/policies", "", "")
+		assert.Equal(t, http.StatusUnauthorized, status)
+		status, _ = serve(t, testHandler, http.MethodGet, "/auth/policies", userToken, "")
+		assert.Equal(t, http.StatusForbidden, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/policies", userToken, `{"table_name": "todos", "action": "read"}`)
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	var id int64
+	t.Run("create", func(t *testing.T) {
+		body := `{"description": "public todos", "table_name": "todos", "action": "read", "expression": "public = true"}`
+		status, _ := serve(t, testHandler, http.MethodPost, "/auth/policies", token, body)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/policies", token, body)
+		assert.Equal(t, http.StatusConflict, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/policies", token, `{"table_name": "todos", "action": "update", "expression": "public ="}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/policies", token, `{"table_name": "todos", "action": "archive"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		// an empty expression would allow everyone
+		status, msg := serve(t, testHandler, http.MethodPost, "/auth/policies", token, `{"table_name": "todos", "action": "delete"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Contains(t, string(msg), "expression is required")
+		long := `{"table_name": "todos", "action": "delete", "expression": "` + strings.Repeat("public or ", 110) + `public"}`
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/policies", token, long)
+		assert.Equal(t, http.StatusBadRequest, status)
+		hasPerm, _ := store.HasPerm(&User{}, "todos", ActionDelete)
+		assert.False(t, hasPerm)
+
+		status, data := serve(t, testHandler, http.MethodGet, "/auth/policies", token, "")
+		assert.Equal(t, http.StatusOK, status)
+		var policies []Policy
+		assert.Nil(t, json.Unmarshal(data, &policies))
+		for _, p := range policies {
+			if p.TableName == "todos" {
+				id = p.ID
+			}
+		}
+		assert.NotZero(t, id)
+		// the cached policies are invalidated
+		assert.Equal(t, "public = true", store.Policies()["todos"]["read"])
+	})
+
+	path := "/auth/policies/" + strconv.FormatInt(id, 10)
+	t.Run("update", func(t *testing.T) {
+		body := `{"description": "public todos", "table_name": "todos", "action": "read", "expression": "true", "denied_columns": ["secret"]}`
+		status, _ := serve(t, testHandler, http.MethodPut, path, token, body)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPut, path, token, `{"table_name": "auth_users", "action": "all", "expression": "true"}`)
+		assert.Equal(t, http.StatusConflict, status)
+		status, _ = serve(t, testHandler, http.MethodPut, "/auth/policies/0", token, body)
+		assert.Equal(t, http.StatusMethodNotAllowed, status)
+		status, _ = serve(t, testHandler, http.MethodPut, "/auth/policies/100000", token, body)
+		assert.Equal(t, http.StatusNotFound, status)
+
+		status, data := serve(t, testHandler, http.MethodGet, path, token, "")
+		assert.Equal(t, http.StatusOK, status)
+		policy := Policy{}
+		assert.Nil(t, json.Unmarshal(data, &policy))
+		assert.Equal(t, "true", policy.Expression)
+		assert.Equal(t, []string{"secret"}, policy.DeniedColumns)
+		hasPerm, _, columns := store.PermittedColumns(&User{}, "todos", ActionRead)
+		assert.True(t, hasPerm)
+		assert.False(t, columns.Permits("secret"))
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodDelete, path, userToken, "")
+		assert.Equal(t, http.StatusForbidden, status)
+		status, _ = serve(t, testHandler, http.MethodDelete, path, token, "")
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodDelete, path, token, "")
+		assert.Equal(t, http.StatusNotFound, status)
+		status, _ = serve(t, testHandler, http.MethodGet, path, token, "")
+		assert.Equal(t, http.StatusNotFound, status)
+		_, ok := store.Policies()["todos"]
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// The name of the refresh tokens table
+	RefreshTokenTableName = "auth_refresh_tokens"
+
+	createRefreshToken = `
+		INSERT INTO auth_refresh_tokens (user_id, family, token_hash, expires_at)
+		VALUES (?, ?, ?, ?)
+	`
+	queryRefreshToken = `
+		SELECT id, user_id, family, expires_at, used, revoked
+		FROM auth_refresh_tokens WHERE token_hash = ?
+	`
+	useRefreshToken     = `UPDATE auth_refresh_tokens SET used = true WHERE id = ? AND used = false AND revoked = false`
+	revokeRefreshFamily = `UPDATE auth_refresh_tokens SET revoked = true WHERE family = ?`
+)
+
+// errRefreshTokenReused is returned when a used or revoked refresh token is
+// presented again, the whole token family is revoked in this case
+var errRefreshTokenReused = errors.New("refresh token reused, all the tokens in the family are revoked")
+
+// tokenResponse is the response of login and refresh
+type tokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// genToken generate an opaque random token
+func genToken() (string, error) {
+	length := 32
+	randomBytes := make([]byte, length)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// hashToken returns the sha256 hash of an opaque token, only the hash is
+// stored in database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken creates a new refresh token in the family, a new family
+// is created when family is empty
+func issueRefreshToken(ctx context.Context, db *sql.DB, userID int64, family string, ttl time.Duration) (string, error) {
+	if family == "" {
+		var err error
+		family, err = genToken()
+		if err != nil {
+			return "", err
+		}
+	}
+	token, err := genToken()
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(ttl).Unix()
+	_, dbErr := db.ExecQuery(ctx, createRefreshToken, userID, family, hashToken(token), expiresAt)
+	if dbErr != nil {
+		return "", dbErr
+	}
+	return token, nil
+}
+
+// revokeRefreshToken revokes the family of the refresh token
+func revokeRefreshToken(ctx context.Context, db *sql.DB, token string) error {
+	rows, dbErr := db.FetchData(ctx, queryRefreshToken, hashToken(token))
+	if dbErr != nil {
+		return dbErr
+	}
+	for _, row := range rows {
+		if _, dbErr := db.ExecQuery(ctx, revokeRefreshFamily, row["family"]); dbErr != nil {
+			return dbErr
+		}
+	}
+	return nil
+}
+
+// rotateRefreshToken marks the refresh token as used and returns the user id
+// and family of it, a new token in the same family should be issued then.
+// If the token was used before, the whole family is revoked.
+func rotateRefreshToken(ctx context.Context, db *sql.DB, logger Logger, token string) (userID int64, family string, err error) {
+	row, dbErr := db.FetchOne(ctx, queryRefreshToken, hashToken(token))
+	if dbErr != nil {
+		var sqlErr sql.Error
+		if errors.As(dbErr, &sqlErr) && sqlErr.Code == http.StatusNotFound {
+			return 0, "", errors.New("invalid refresh token")
+		}
+		return 0, "", dbErr
+	}
+
+	family = row["family"].(string)
+	if toBool(row["used"]) || toBool(row["revoked"]) {
+		logger.Warnf("refresh token reused, revoke family: %s", family)
+		if _, dbErr := db.ExecQuery(ctx, revokeRefreshFamily, family); dbErr != nil {
+			return 0, "", dbErr
+		}
+		return 0, "", errRefreshTokenReused
+	}
+	if toInt64(row["expires_at"]) < time.Now().Unix() {
+		return 0, "", errors.New("refresh token expired")
+	}
+
+	rows, dbErr := db.ExecQuery(ctx, useRefreshToken, toInt64(row["id"]))
+	if dbErr != nil {
+		return 0, "", dbErr
+	}
+	if rows != 1 {
+		// the token is used by a concurrent request
+		if _, dbErr := db.ExecQuery(ctx, revokeRefreshFamily, family); dbErr != nil {
+			return 0, "", dbErr
+		}
+		return 0, "", errRefreshTokenReused
+	}
+	return toInt64(row["user_id"]), family, nil
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// The name of the revoked tokens table
+	RevokedTokenTableName = "auth_revoked_tokens"
+
+	createRevokedToken      = `INSERT INTO auth_revoked_tokens (jti, expires_at) VALUES (?, ?)`
+	queryRevokedToken       = `SELECT jti FROM auth_revoked_tokens WHERE jti = ?`
+	deleteExpiredRevoked    = `DELETE FROM auth_revoked_tokens WHERE expires_at < ?`
+	queryTokenVersion       = `SELECT token_version FROM auth_users WHERE id = ?`
+	bumpTokenVersion        = `UPDATE auth_users SET token_version = token_version + 1 WHERE id = ?`
+	revokeUserRefreshTokens = `UPDATE auth_refresh_tokens SET revoked = true WHERE user_id = ?`
+
+	// DefaultRevocationCacheTTL is how long the revocation state of a token or
+	// the token version of a user is cached in memory
+	DefaultRevocationCacheTTL = 30 * time.Second
+)
+
+// RevocationStore stores revoked tokens and per-user token versions, a token
+// is revoked if its `jti` is revoked or its `ver` is older than the current
+// token version of the user
+type RevocationStore interface {
+	// Revoke revokes the token with jti, it can be forgotten after expiresAt
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	// IsRevoked returns whether the token with jti is revoked
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	// RevokeUser revokes all the tokens of a user by bumping the token version
+	RevokeUser(ctx context.Context, userID int64) error
+	// TokenVersion returns the current token version of a user
+	TokenVersion(ctx context.Context, userID int64) (int64, error)
+}
+
+// DBRevocationStore is a RevocationStore backed by database tables with an
+// in-memory cache, a revocation made by another instance takes effect after
+// the cache ttl at most
+type DBRevocationStore struct {
+	db       *sql.DB
+	logger   Logger
+	revoked  *ttlCache[string, bool]
+	versions *ttlCache[int64, int64]
+}
+
+// NewRevocationStore returns a DBRevocationStore, cacheTTL is how long the
+// state is cached in memory, 0 means DefaultRevocationCacheTTL. The Logging
+// option sets the logger of the store.
+func NewRevocationStore(db *sql.DB, cacheTTL time.Duration, opts ...Option) *DBRevocationStore {
+	if cacheTTL == 0 {
+		cacheTTL = DefaultRevocationCacheTTL
+	}
+	return &DBRevocationStore{
+		db:       db,
+		logger:   newOptions(opts).logger,
+		revoked:  newTTLCache[string, bool](cacheTTL),
+		versions: newTTLCache[int64, int64](cacheTTL),
+	}
+}
+
+// Revoke implements RevocationStore interface, revoking a revoked token again
+// is not an error
+func (s *DBRevocationStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	// expired tokens don't need to be remembered any more
+	if _, dbErr := s.db.ExecQuery(ctx, deleteExpiredRevoked, time.Now().Unix()); dbErr != nil {
+		s.logger.Warnf("delete expired revoked tokens error: %v", dbErr)
+	}
+	if _, dbErr := s.db.ExecQuery(ctx, createRevokedToken, jti, expiresAt.Unix()); dbErr != nil {
+		// the insert fails on the unique jti if the token is revoked already
+		rows, err := s.db.FetchData(ctx, queryRevokedToken, jti)
+		if err != nil || len(rows) == 0 {
+			return dbErr
+		}
+	}
+	s.revoked.set(jti, true, expiresAt)
+	return nil
+}
+
+// IsRevoked implements RevocationStore interface
+func (s *DBRevocationStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	if revoked, ok := s.revoked.get(jti); ok {
+		return revoked, nil
+	}
+	rows, dbErr := s.db.FetchData(ctx, queryRevokedToken, jti)
+	if dbErr != nil {
+		return false, dbErr
+	}
+	revoked := len(rows) > 0
+	s.revoked.set(jti, revoked, time.Time{})
+	return revoked, nil
+}
+
+// RevokeUser implements RevocationStore interface, the refresh tokens of the
+// user are revoked as well
+func (s *DBRevocationStore) RevokeUser(ctx context.Context, userID int64) error {
+	rows, dbErr := s.db.ExecQuery(ctx, bumpTokenVersion, userID)
+	if dbErr != nil {
+		return dbErr
+	}
+	if rows == 0 {
+		return sql.NewError(http.StatusNotFound, fmt.Sprintf("user not found: %d", userID))
+	}
+	if _, dbErr := s.db.ExecQuery(ctx, revokeUserRefreshTokens, userID); dbErr != nil {
+		return dbErr
+	}
+	s.versions.delete(userID)
+	return nil
+}
+
+// TokenVersion implements RevocationStore interface
+func (s *DBRevocationStore) TokenVersion(ctx context.Context, userID int64) (int64, error) {
+	if version, ok := s.versions.get(userID); ok {
+		return version, nil
+	}
+	row, dbErr := s.db.FetchOne(ctx, queryTokenVersion, userID)
+	if dbErr != nil {
+		return 0, dbErr
+	}
+	version := toInt64(row["token_version"])
+	s.versions.set(userID, version, time.Time{})
+	return version, nil
+}
+
+// isTokenRevoked checks the jti and ver claims of a token against store
+func isTokenRevoked(ctx context.Context, store RevocationStore, claims map[string]any) (bool, error) {
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		revoked, err := store.IsRevoked(ctx, jti)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+	userID := toInt64(claims["user_id"])
+	if userID == 0 {
+		return false, nil
+	}
+	version, err := store.TokenVersion(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return toInt64(claims["ver"]) < version, nil
+}
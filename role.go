@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	j "github.com/rest-go/rest/pkg/jsonutil"
+	"github.com/rest-go/rest/pkg/sql"
+)
+
+const (
+	// The name of the roles table
+	RoleTableName = "auth_roles"
+	// The name of the user roles table
+	UserRoleTableName = "auth_user_roles"
+
+	createRole      = `INSERT INTO auth_roles (name, description) VALUES (?, ?)`
+	queryRoles      = `SELECT id, name, description FROM auth_roles ORDER BY name`
+	queryRoleByName = `SELECT id FROM auth_roles WHERE name = ?`
+	queryUserRoles  = `
+		SELECT r.name FROM auth_roles r
+		JOIN auth_user_roles ur ON ur.role_id = r.id
+		WHERE ur.user_id = ?
+		ORDER BY r.name
+	`
+	assignUserRole = `INSERT INTO auth_user_roles (user_id, role_id) VALUES (?, ?)`
+	revokeUserRole = `DELETE FROM auth_user_roles WHERE user_id = ? AND role_id = ?`
+)
+
+var nameExp = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+// Role represents a named set of privileges granted to users, policies check
+// roles with `auth_user.has_role('<name>')`
+type Role struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Validate returns an error if the role name is invalid
+func (r *Role) Validate() error {
+	if !nameExp.MatchString(r.Name) {
+		return fmt.Errorf("invalid role name %q, only letters, digits and _.:- are allowed", r.Name)
+	}
+	return nil
+}
+
+// fetchUserRoles returns the names of the roles assigned to user
+func fetchUserRoles(ctx context.Context, db *sql.DB, userID int64) ([]string, error) {
+	rows, dbErr := db.FetchData(ctx, queryUserRoles, userID)
+	if dbErr != nil {
+		return nil, dbErr
+	}
+	roles := make([]string, 0, len(rows))
+	for _, row := range rows {
+		roles = append(roles, toString(row["name"]))
+	}
+	return roles, nil
+}
+
+// fetchRoleID returns the id of the role by name
+func fetchRoleID(ctx context.Context, db *sql.DB, name string) (int64, error) {
+	row, dbErr := db.FetchOne(ctx, queryRoleByName, name)
+	if dbErr != nil {
+		return 0, dbErr
+	}
+	return toInt64(row["id"]), nil
+}
+
+// parseNames converts the roles or groups claim to names
+func parseNames(v any) []string {
+	items, ok := v.([]any)
+	if !ok {
+		return nil
+	}
+	roles := make([]string, 0, len(items))
+	for _, item := range items {
+		if role, ok := item.(string); ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// roles serves the admin endpoints of roles
+//
+//	GET  roles                list roles, or the roles of a user with ?user_id=
+//	POST roles                create a role
+//	POST roles/assign         assign a role to a user
+//	POST roles/unassign       unassign a role from a user
+func (h *Handler) roles(r *http.Request, action string) any {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	if _, res := h.requireAdmin(ctx, r); res != nil {
+		return res
+	}
+
+	switch {
+	case action == "roles" && r.Method == http.MethodGet:
+		return h.listRoles(ctx, r)
+	case action == "roles" && r.Method == http.MethodPost:
+		return h.createRole(ctx, r)
+	case action == "roles/assign" && r.Method == http.MethodPost:
+		return h.assignRole(ctx, r, assignUserRole)
+	case action == "roles/unassign" && r.Method == http.MethodPost:
+		return h.assignRole(ctx, r, revokeUserRole)
+	case action == "roles" || action == "roles/assign" || action == "roles/unassign":
+		return &j.Response{
+			Code: http.StatusMethodNotAllowed,
+			Msg:  fmt.Sprintf("method not supported: %s", r.Method),
+		}
+	}
+	return &j.Response{
+		Code: http.StatusBadRequest,
+		Msg:  "action not supported",
+	}
+}
+
+func (h *Handler) listRoles(ctx context.Context, r *http.Request) any {
+	if v := r.URL.Query().Get("user_id"); v != "" {
+		userID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return &j.Response{
+				Code: http.StatusBadRequest,
+				Msg:  fmt.Sprintf("invalid user_id: %s", v),
+			}
+		}
+		roles, err := fetchUserRoles(ctx, h.db, userID)
+		if err != nil {
+			h.options.logger.Errorf("fetch user roles error: %v", err)
+			return j.ErrResponse(err)
+		}
+		return roles
+	}
+
+	rows, dbErr := h.db.FetchData(ctx, queryRoles)
+	if dbErr != nil {
+		h.options.logger.Errorf("fetch roles error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+	roles := make([]Role, 0, len(rows))
+	for _, row := range rows {
+		roles = append(roles, Role{
+			ID:          toInt64(row["id"]),
+			Name:        toString(row["name"]),
+			Description: toString(row["description"]),
+		})
+	}
+	return roles
+}
+
+func (h *Handler) createRole(ctx context.Context, r *http.Request) any {
+	role := &Role{}
+	if err := json.NewDecoder(r.Body).Decode(role); err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data",
+		}
+	}
+	if err := role.Validate(); err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+		}
+	}
+	if _, dbErr := h.db.ExecQuery(ctx, createRole, role.Name, role.Description); dbErr != nil {
+		h.options.logger.Errorf("create role error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
+
+// assignRole assigns or unassigns a role with query, the change takes effect
+// when the user gets a new token
+func (h *Handler) assignRole(ctx context.Context, r *http.Request, query string) any {
+	var data struct {
+		UserID int64  `json:"user_id"`
+		Role   string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil || data.UserID == 0 || data.Role == "" {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data, user_id and role are required",
+		}
+	}
+	if _, dbErr := h.db.FetchOne(ctx, queryUserByID, data.UserID); dbErr != nil {
+		return j.ErrResponse(dbErr)
+	}
+	roleID, err := fetchRoleID(ctx, h.db, data.Role)
+	if err != nil {
+		return j.ErrResponse(err)
+	}
+	if _, dbErr := h.db.ExecQuery(ctx, query, data.UserID, roleID); dbErr != nil {
+		h.options.logger.Errorf("assign role error: %v", dbErr)
+		return j.ErrResponse(dbErr)
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
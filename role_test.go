@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// adminToken returns an access token of the admin user created by setup
+func adminToken(t *testing.T) string {
+	ctx := context.Background()
+	row, err := testHandler.db.FetchOne(ctx, "SELECT id, username FROM auth_users WHERE is_admin = true")
+	assert.Nil(t, err)
+	admin := &User{ID: toInt64(row["id"]), Username: toString(row["username"]), IsAdmin: true}
+	res, ok := testHandler.issueTokens(ctx, admin, "").(*tokenResponse)
+	assert.True(t, ok)
+	return res.Token
+}
+
+// serve sends a request with token to handler and returns the response
+// status and body
+func serve(t *testing.T, handler http.Handler, method, path, token, body string) (int, []byte) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		req.Header.Set(AuthorizationHeader, "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	res := w.Result()
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	return res.StatusCode, data
+}
+
+func TestHandlerRoles(t *testing.T) {
+	status, _ := serve(t, testHandler, http.MethodPost, "/auth/register", "", `{"username": "role_user", "password": "world"}`)
+	assert.Equal(t, http.StatusOK, status)
+	userToken := login(t, "role_user", "world")["token"]
+	userData, err := ParseJWTToken(testKey, userToken)
+	assert.Nil(t, err)
+	userID := toInt64(userData["user_id"])
+	token := adminToken(t)
+
+	t.Run("admin required", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodGet, "/auth/roles", "", "")
+		assert.Equal(t, http.StatusUnauthorized, status)
+		status, _ = serve(t, testHandler, http.MethodGet, "/auth/roles", userToken, "")
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodPost, "/auth/roles", token, `{"name": "editor", "description": "edit articles"}`)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/roles", token, `{"name": "editor"}`)
+		assert.NotEqual(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/roles", token, `{"name": "chief editor"}`)
+		assert.Equal(t, http.StatusBadRequest, status)
+
+		status, data := serve(t, testHandler, http.MethodGet, "/auth/roles", token, "")
+		assert.Equal(t, http.StatusOK, status)
+		var roles []Role
+		assert.Nil(t, json.Unmarshal(data, &roles))
+		assert.Equal(t, 1, len(roles))
+		assert.Equal(t, "editor", roles[0].Name)
+		assert.Equal(t, "edit articles", roles[0].Description)
+	})
+
+	t.Run("assign", func(t *testing.T) {
+		body := `{"user_id": ` + strconv.FormatInt(userID, 10) + `, "role": "editor"}`
+		status, _ := serve(t, testHandler, http.MethodPost, "/auth/roles/assign", token, body)
+		assert.Equal(t, http.StatusOK, status)
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/roles/assign", token, `{"user_id": 1, "role": "writer"}`)
+		assert.Equal(t, http.StatusNotFound, status)
+
+		status, data := serve(t, testHandler, http.MethodGet, "/auth/roles?user_id="+strconv.FormatInt(userID, 10), token, "")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, `["editor"]`, strings.TrimSpace(string(data)))
+
+		// roles are in the new token
+		data2, err := ParseJWTToken(testKey, login(t, "role_user", "world")["token"])
+		assert.Nil(t, err)
+		user, err := newUserFromClaims(data2, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"editor"}, user.Roles)
+		assert.True(t, user.HasRole("editor"))
+		assert.Nil(t, user.Claims["roles"])
+
+		policies := map[string]map[string]string{
+			"articles": {"update": "auth_user.has_role('editor') or author_id = auth_user.id"},
+		}
+		hasPerm, userIDColumn := user.HasPerm("articles", ActionUpdate, policies)
+		assert.True(t, hasPerm)
+		assert.Equal(t, "", userIDColumn)
+
+		status, _ = serve(t, testHandler, http.MethodPost, "/auth/roles/unassign", token, body)
+		assert.Equal(t, http.StatusOK, status)
+		_, data = serve(t, testHandler, http.MethodGet, "/auth/roles?user_id="+strconv.FormatInt(userID, 10), token, "")
+		assert.Equal(t, `[]`, strings.TrimSpace(string(data)))
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		status, _ := serve(t, testHandler, http.MethodDelete, "/auth/roles", token, "")
+		assert.Equal(t, http.StatusMethodNotAllowed, status)
+	})
+}
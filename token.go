@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	defaultTokenTTL        = 15 * time.Minute
+	defaultRefreshTokenTTL = 14 * 24 * time.Hour
+	defaultResetTokenTTL   = time.Hour
+	defaultVerifyTokenTTL  = 24 * time.Hour
+)
+
+// reservedClaims are the claims set by this package, custom claims can't
+// override them
+var reservedClaims = map[string]struct{}{
+	"sub":      {},
+	"iat":      {},
+	"nbf":      {},
+	"exp":      {},
+	"iss":      {},
+	"aud":      {},
+	"jti":      {},
+	"ver":      {},
+	"user_id":  {},
+	"is_admin": {},
+	"roles":    {},
+	"groups":   {},
+
+	"email_verified": {},
+}
+
+// ClaimsBuilderFunc returns custom claims to be embedded in the tokens issued
+// to user, e.g. tenant id or display name, the reserved claims like `roles` are
+// ignored
+type ClaimsBuilderFunc func(ctx context.Context, user *User) (map[string]any, error)
+
+// ClaimsParserFunc reads custom claims into user, it's called after the custom
+// claims are copied to User.Claims, an error results in an anonymous user
+type ClaimsParserFunc func(claims map[string]any, user *User) error
+
+// TokenOptions configures the claims of tokens issued by Handler, and how
+// tokens are validated by Handler and Middleware
+type TokenOptions struct {
+	// TTL is the lifetime of access tokens, default to 15 minutes
+	TTL time.Duration
+	// RefreshTTL is the lifetime of refresh tokens, default to 14 days
+	RefreshTTL time.Duration
+	// ResetTTL is the lifetime of password reset tokens, default to 1 hour
+	ResetTTL time.Duration
+	// VerifyTTL is the lifetime of email verification tokens, default to 1 day
+	VerifyTTL time.Duration
+	// Issuer is the `iss` claim, tokens from other issuers are rejected if set
+	Issuer string
+	// Audience is the `aud` claim, tokens without any of the audiences are
+	// rejected if set
+	Audience []string
+	// NotBefore delays the `nbf` claim after the `iat` claim
+	NotBefore time.Duration
+	// Leeway is the clock skew allowed when validating `exp`, `nbf` and `iat`
+	Leeway time.Duration
+}
+
+func (opts *TokenOptions) withDefaults() TokenOptions {
+	o := *opts
+	if o.TTL == 0 {
+		o.TTL = defaultTokenTTL
+	}
+	if o.RefreshTTL == 0 {
+		o.RefreshTTL = defaultRefreshTokenTTL
+	}
+	if o.ResetTTL == 0 {
+		o.ResetTTL = defaultResetTokenTTL
+	}
+	if o.VerifyTTL == 0 {
+		o.VerifyTTL = defaultVerifyTokenTTL
+	}
+	return o
+}
+
+// registeredClaims returns the registered claims for a token issued to user
+// at now
+func (opts *TokenOptions) registeredClaims(userID int64, now time.Time) map[string]any {
+	claims := map[string]any{
+		"sub": strconv.FormatInt(userID, 10),
+		"iat": now.Unix(),
+		"nbf": now.Add(opts.NotBefore).Unix(),
+		"exp": now.Add(opts.TTL).Unix(),
+	}
+	if opts.Issuer != "" {
+		claims["iss"] = opts.Issuer
+	}
+	if len(opts.Audience) == 1 {
+		claims["aud"] = opts.Audience[0]
+	} else if len(opts.Audience) > 1 {
+		claims["aud"] = opts.Audience
+	}
+	return claims
+}
+
+// validate validates the registered claims
+func (opts *TokenOptions) validate(claims map[string]any) error {
+	mapClaims := jwt.MapClaims(claims)
+	now := time.Now()
+	if !mapClaims.VerifyExpiresAt(now.Add(-opts.Leeway).Unix(), false) {
+		return errors.New("token is expired")
+	}
+	if !mapClaims.VerifyIssuedAt(now.Add(opts.Leeway).Unix(), false) {
+		return errors.New("token used before issued")
+	}
+	if !mapClaims.VerifyNotBefore(now.Add(opts.Leeway).Unix(), false) {
+		return errors.New("token is not valid yet")
+	}
+	if opts.Issuer != "" && !mapClaims.VerifyIssuer(opts.Issuer, true) {
+		return errors.New("token has invalid issuer")
+	}
+	if len(opts.Audience) > 0 {
+		for _, aud := range opts.Audience {
+			if mapClaims.VerifyAudience(aud, true) {
+				return nil
+			}
+		}
+		return errors.New("token has invalid audience")
+	}
+	return nil
+}
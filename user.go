@@ -4,37 +4,68 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base32"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
 	"strings"
 
-	"github.com/rest-go/rest/pkg/log"
+	j "github.com/rest-go/rest/pkg/jsonutil"
 	"github.com/rest-go/rest/pkg/sql"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	// The name of the users table
 	UserTableName = "auth_users"
 
-	createUserTable = `
-	CREATE TABLE auth_users (
-		id %s,
-		username VARCHAR(32) UNIQUE NOT NULL,
-		password VARCHAR(72) NOT NULL,
-		is_admin bool NOT NULL DEFAULT false
-	)
-	`
-	createAdminUser = `INSERT INTO auth_users (username, password, is_admin) VALUES (?, ?, true)`
-	createUser      = `INSERT INTO auth_users (username, password) VALUES (?, ?)`
-	queryUser       = `SELECT id, username, password, is_admin FROM auth_users WHERE username = ?`
+	createAdminUser    = `INSERT INTO auth_users (username, password, is_admin) VALUES (?, ?, true)`
+	createUser         = `INSERT INTO auth_users (username, password, email) VALUES (?, ?, ?)`
+	queryUser          = `SELECT id, username, password, is_admin, email, email_verified FROM auth_users WHERE username = ?`
+	queryUserByEmail   = `SELECT id, username, password, is_admin, email, email_verified FROM auth_users WHERE email = ?`
+	queryUserByID      = `SELECT id, username, is_admin, email, email_verified FROM auth_users WHERE id = ?`
+	queryUserPassword  = `SELECT id, username, password, is_admin, email, email_verified FROM auth_users WHERE id = ?`
+	updateUserPassword = `UPDATE auth_users SET password = ? WHERE id = ?`
+	verifyUserEmail    = `UPDATE auth_users SET email_verified = true WHERE id = ?`
+	// the old hash is checked to not overwrite a password changed meanwhile
+	rehashPassword = `UPDATE auth_users SET password = ? WHERE id = ? AND password = ?`
 )
 
 // User represents a request user
 type User struct {
-	ID       int64  `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	IsAdmin  bool   `json:"is_admin"`
+	ID       int64          `json:"id"`
+	Username string         `json:"username"`
+	Password string         `json:"password"`
+	IsAdmin  bool           `json:"is_admin"`
+	Roles    []string       `json:"roles,omitempty"`
+	Groups   []string       `json:"groups,omitempty"`
+	Claims   map[string]any `json:"claims,omitempty"` // custom claims in token
+	// Email is optional, it's stored in lower case
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// newUserFromRow creates user from a row of the users table, the password is
+// not copied
+func newUserFromRow(row map[string]any) *User {
+	return &User{
+		ID:            toInt64(row["id"]),
+		Username:      toString(row["username"]),
+		IsAdmin:       toBool(row["is_admin"]),
+		Email:         toString(row["email"]),
+		EmailVerified: toBool(row["email_verified"]),
+	}
+}
+
+// normalizeEmail returns email in lower case, an error is returned if it's
+// not a valid address
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || len(email) > maxEmailLength {
+		return "", fmt.Errorf("invalid email: %q", email)
+	}
+	return email, nil
 }
 
 // IsAuthenticated returns a bool to indicate whether user is anonymous
@@ -47,53 +78,158 @@ func (u *User) IsAuthenticated() bool {
 	return u.ID != 0
 }
 
-func (u *User) hasPerm(exp string) (hasPerm bool, withUserIDColumn string) {
-	// remove all the spaces in expression
-	exp = strings.ReplaceAll(exp, " ", "")
-	// if ask a admin user perm
-	if exp == "" {
-		return true, ""
-	} else if exp == "auth_user.is_admin" {
-		return u.IsAdmin, ""
-	} else if exp == "auth_user.is_authenticated" {
-		return u.IsAuthenticated(), ""
-	} else if strings.HasSuffix(exp, "=auth_user.id") {
-		return u.IsAuthenticated(), strings.TrimSuffix(exp, "=auth_user.id")
+// HasRole returns whether the role is assigned to user
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// InGroup returns whether user is a member of the group, directly or through
+// a child group
+func (u *User) InGroup(group string) bool {
+	for _, g := range u.Groups {
+		if g == group {
+			return true
+		}
 	}
+	return false
+}
+
+// Claim returns the custom claim in token by name, nil if not found
+func (u *User) Claim(name string) any {
+	return u.Claims[name]
+}
 
-	log.Errorf("invalid policy exp: %s, return false", exp)
-	return false, ""
+// hasPerm evaluates the policy expression exp, withUserIDColumn is returned
+// when exp limits rows to the ones owned by the user, e.g. `user_id = auth_user.id`
+func (u *User) hasPerm(exp string, logger Logger) (hasPerm bool, withUserIDColumn string) {
+	hasPerm, withUserIDColumn, _, _ = u.decide(exp, logger)
+	return hasPerm, withUserIDColumn
 }
 
-// HasPerm check whether user has permission to perform action on the table with provided policies
+// decide evaluates the policy expression exp like hasPerm, and returns the
+// remaining expression on columns and the reason of the decision
+func (u *User) decide(exp string, logger Logger) (hasPerm bool, withUserIDColumn string, residual expr, reason string) {
+	e, err := parseExpression(exp)
+	if err != nil {
+		logger.Errorf("invalid policy exp: %s, %v, return false", exp, err)
+		return false, "", nil, fmt.Sprintf("invalid expression: %v", err)
+	}
+	residual = eval(e, u)
+	switch r := residual.(type) {
+	case *literalExpr:
+		if truthy(r.value) {
+			return true, "", residual, "expression is true for the user"
+		}
+		return false, "", residual, "expression is false for the user"
+	case *compareExpr:
+		if column, ok := userIDColumn(r); ok {
+			if u.IsAnonymous() {
+				return false, column, residual, fmt.Sprintf("rows are limited by %s, login required", column)
+			}
+			return true, column, residual, fmt.Sprintf("rows are limited by %s", column)
+		}
+	}
+
+	// a row filter policy is denied here, it's expected rather than an error
+	debugf(logger, "policy exp: %s depends on columns other than user id, return false", exp)
+	return false, "", residual, "expression depends on columns other than user id, use a row filter"
+}
+
+// userIDColumn returns the column of an evaluated `<column> = auth_user.id`
+// expression
+func userIDColumn(e *compareExpr) (string, bool) {
+	if e.op != "=" {
+		return "", false
+	}
+	left, right := e.left, e.right
+	if _, ok := left.(*literalExpr); ok {
+		left, right = right, left
+	}
+	column, ok := left.(*columnExpr)
+	if !ok {
+		return "", false
+	}
+	if l, ok := right.(*literalExpr); !ok || l.ref != "auth_user.id" {
+		return "", false
+	}
+	return column.name, true
+}
+
+// HasPerm check whether user has permission to perform action on the table with provided policies.
+// A policy depending on columns other than the user id column, e.g.
+// `public = true or owner_id = auth_user.id`, is denied, use RowFilter for it.
 func (u *User) HasPerm(table string, action Action, policies map[string]map[string]string) (hasPerm bool, withUserIDColumn string) {
+	return u.tablePerm(table, action, policies, defaultLogger{})
+}
+
+func (u *User) tablePerm(table string, action Action, policies map[string]map[string]string, logger Logger) (bool, string) {
 	if policies == nil {
-		log.Warnf("nil policies")
+		logger.Warnf("nil policies")
 		return false, ""
 	}
+	return u.hasPerm(policyExpression(table, action, policies), logger)
+}
+
+// policyExpression returns the expression of the policy on table for action,
+// an empty expression is returned if there is no policy
+func policyExpression(table string, action Action, policies map[string]map[string]string) string {
+	exp, _ := lookupPolicy(table, action, policies, nil)
+	return exp
+}
+
+// lookupPolicy returns the policy on table for action, a bulk action falls
+// back to its base action, then to the `all` action, then to the `all` table.
+// Each lookup is appended to trace if it's not nil.
+func lookupPolicy[T any](table string, action Action, policies map[string]map[string]T, trace *[]string) (policy T, ok bool) {
+	try := func(table, action string) (T, bool) {
+		p, ok := policies[table][action]
+		if trace != nil {
+			result := "not found"
+			if ok {
+				result = "matched"
+			}
+			*trace = append(*trace, fmt.Sprintf("%s.%s: %s", table, action, result))
+		}
+		return p, ok
+	}
 
-	var ps map[string]string
 	ps, ok := policies[table]
-	defaultTablePerm := policies["all"]
+	lookupTable := table
 	if !ok {
-		ps = defaultTablePerm
+		lookupTable = "all"
+		ps = policies[lookupTable]
+		if trace != nil {
+			*trace = append(*trace, fmt.Sprintf("%s: no policies, fall back to table all", table))
+		}
 	}
 	if len(ps) > 0 {
-		if exp, ok := ps[action.String()]; ok {
-			return u.hasPerm(exp)
-		} else if exp, ok := ps["all"]; ok {
-			return u.hasPerm(exp)
-		} else {
-			return u.hasPerm(defaultTablePerm["all"])
+		if p, ok := try(lookupTable, action.String()); ok {
+			return p, true
+		}
+		if action.IsBulk() {
+			if p, ok := try(lookupTable, action.Base().String()); ok {
+				return p, true
+			}
+		}
+		if p, ok := try(lookupTable, "all"); ok {
+			return p, true
+		}
+		if lookupTable != "all" {
+			return try("all", "all")
 		}
 	}
-
-	return true, ""
+	return policy, false
 }
 
-// HashPassword generate the hashed password for a plain password
+// HashPassword generate the hashed password for a plain password with
+// DefaultPasswordHasher
 func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := DefaultPasswordHasher.Hash(password)
 	if err != nil {
 		return "", fmt.Errorf("generate hashed password error %w", err)
 	}
@@ -109,29 +245,83 @@ func genPasswd(length int) (string, error) {
 	return base32.StdEncoding.EncodeToString(randomBytes)[:length], nil
 }
 
-// setupUsers create `users` table and create an admin user
-func setupUsers(db *sql.DB) (username, password string, err error) {
-	log.Info("create users table")
-	idSQL := primaryKeySQL[db.DriverName]
-	createTableQuery := fmt.Sprintf(createUserTable, idSQL)
+// setupUsers create an admin user, username and password are generated if
+// they are empty
+func setupUsers(db *sql.DB, username, password string, logger Logger) (string, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
 	defer cancel()
-	_, dbErr := db.ExecQuery(ctx, createTableQuery)
-	if dbErr != nil {
-		return "", "", dbErr
-	}
 
-	log.Info("create a admin user")
-	username = adminUsername
-	length := 12
-	password, err = genPasswd(length)
-	if err != nil {
-		return "", "", err
+	logger.Infof("create a admin user")
+	if username == "" {
+		username = adminUsername
+	}
+	if password == "" {
+		length := 12
+		var err error
+		password, err = genPasswd(length)
+		if err != nil {
+			return "", "", err
+		}
 	}
 	hashedPassword, err := HashPassword(password)
 	if err != nil {
 		return "", "", err
 	}
-	_, dbErr = db.ExecQuery(ctx, createAdminUser, username, hashedPassword)
+	_, dbErr := db.ExecQuery(ctx, createAdminUser, username, hashedPassword)
 	return username, password, dbErr
 }
+
+// users serves the admin endpoints of users
+//
+//	POST users/<id>/password reset the password of a user
+func (h *Handler) users(r *http.Request, action string) any {
+	ctx, cancel := context.WithTimeout(context.Background(), sql.DefaultTimeout)
+	defer cancel()
+	if _, res := h.requireAdmin(ctx, r); res != nil {
+		return res
+	}
+
+	parts := strings.Split(action, "/")
+	if len(parts) != 3 || parts[2] != "password" {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "action not supported",
+		}
+	}
+	if r.Method != http.MethodPost {
+		return &j.Response{
+			Code: http.StatusMethodNotAllowed,
+			Msg:  fmt.Sprintf("method not supported: %s", r.Method),
+		}
+	}
+	userID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return &j.Response{
+			Code: http.StatusNotFound,
+			Msg:  fmt.Sprintf("user not found: %s", parts[1]),
+		}
+	}
+	return h.resetPassword(ctx, r, userID)
+}
+
+// resetPassword sets the password of the user without the current password,
+// all the tokens of the user are revoked
+func (h *Handler) resetPassword(ctx context.Context, r *http.Request, userID int64) any {
+	var data struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return &j.Response{
+			Code: http.StatusBadRequest,
+			Msg:  "failed to decode json data",
+		}
+	}
+	row, dbErr := h.db.FetchOne(ctx, queryUserByID, userID)
+	if dbErr != nil {
+		return j.ErrResponse(dbErr)
+	}
+	if res := h.setPassword(ctx, newUserFromRow(row), data.Password); res != nil {
+		return res
+	}
+	return &j.Response{Code: http.StatusOK, Msg: "success"}
+}
@@ -39,6 +39,9 @@ var policies = map[string]map[string]string{
 	"notes": {
 		"read": "invalid policy",
 	},
+	"posts": {
+		"all": "auth_user.is_admin or (not auth_user.is_anonymous and author_id = auth_user.id)",
+	},
 }
 
 //nolint:funlen
@@ -163,6 +166,30 @@ func TestUser_HasPerm(t *testing.T) {
 			hasPerm:          false,
 			withUserIDColumn: "",
 		},
+		{
+			name:             "admin users have permission on all posts",
+			user:             User{ID: 1, IsAdmin: true},
+			table:            "posts",
+			action:           ActionUpdate,
+			hasPerm:          true,
+			withUserIDColumn: "",
+		},
+		{
+			name:             "users have permission on own posts",
+			user:             User{ID: 2},
+			table:            "posts",
+			action:           ActionUpdate,
+			hasPerm:          true,
+			withUserIDColumn: "author_id",
+		},
+		{
+			name:             "anonymous users don't have permission on posts",
+			user:             User{ID: 0},
+			table:            "posts",
+			action:           ActionRead,
+			hasPerm:          false,
+			withUserIDColumn: "",
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			hasPerm, userIDColumn := test.user.HasPerm(test.table, test.action, policies)